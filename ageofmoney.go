@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ageOfMoneyWindow is the number of outflows YNAB averages for the age of
+// money
+const ageOfMoneyWindow = 10
+
+// budgetFlow is an inflow (positive) or outflow (negative) of the budget
+type budgetFlow struct {
+	Date   time.Time
+	Amount int
+}
+
+type ageOfMoneyDay struct {
+	Date       string
+	AgeOfMoney int // days
+}
+
+// loadBudgetFlows returns the cash flows of the on-budget accounts ordered by
+// date. Inflows come first on the same day.
+func loadBudgetFlows(ctx context.Context, tx *sql.Tx) ([]budgetFlow, error) {
+	res, err := tx.QueryContext(ctx, `
+		WITH `+budgetLines+`
+		SELECT date, amount
+		FROM budget_line
+		WHERE amount <> 0
+		ORDER BY date, amount DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var flows []budgetFlow
+	for res.Next() {
+		var date string
+		var flow budgetFlow
+		if err := res.Scan(&date, &flow.Amount); err != nil {
+			return nil, err
+		}
+		if flow.Date, err = time.Parse("2006-01-02", date); err != nil {
+			return nil, err
+		}
+		flows = append(flows, flow)
+	}
+	return flows, res.Err()
+}
+
+// ageOfMoney matches outflows to the oldest inflows that are not spent yet
+// (first in, first out). The age of an outflow is the average number of days
+// its money was in the budget, weighted by amount. The age of money of a day
+// is the average age of the last window outflows until the end of that day.
+// Outflows that can't be matched, because more was spent than came in, are
+// ignored. Days without outflows keep the age of the previous day.
+func ageOfMoney(flows []budgetFlow, window int) []ageOfMoneyDay {
+	type inflow struct {
+		Date      time.Time
+		Remaining int
+	}
+	var inflows []inflow
+	var ages []float64
+	var days []ageOfMoneyDay
+
+	for i, flow := range flows {
+		if flow.Amount > 0 {
+			inflows = append(inflows, inflow{flow.Date, flow.Amount})
+		} else {
+			outflow := -flow.Amount
+			var matched int
+			var weightedDays float64
+			for outflow > 0 && len(inflows) > 0 {
+				spent := outflow
+				if inflows[0].Remaining < spent {
+					spent = inflows[0].Remaining
+				}
+				weightedDays += float64(spent) * flow.Date.Sub(inflows[0].Date).Hours() / 24
+				matched += spent
+				outflow -= spent
+				inflows[0].Remaining -= spent
+				if inflows[0].Remaining == 0 {
+					inflows = inflows[1:]
+				}
+			}
+			if matched > 0 {
+				ages = append(ages, weightedDays/float64(matched))
+			}
+		}
+
+		if len(ages) == 0 || (i+1 < len(flows) && flows[i+1].Date.Equal(flow.Date)) {
+			continue
+		}
+		// the last flow of the day, fill the days since the previous flow
+		recent := ages
+		if len(recent) > window {
+			recent = recent[len(recent)-window:]
+		}
+		var sum float64
+		for _, age := range recent {
+			sum += age
+		}
+		age := int(math.Round(sum / float64(len(recent))))
+
+		date := flow.Date
+		if len(days) > 0 {
+			previous, _ := time.Parse("2006-01-02", days[len(days)-1].Date)
+			for d := previous.AddDate(0, 0, 1); d.Before(date); d = d.AddDate(0, 0, 1) {
+				days = append(days, ageOfMoneyDay{d.Format("2006-01-02"), days[len(days)-1].AgeOfMoney})
+			}
+		}
+		days = append(days, ageOfMoneyDay{date.Format("2006-01-02"), age})
+	}
+	return days
+}
+
+// updateAgeOfMoneyDaily replaces the content of age_of_money_daily.
+func updateAgeOfMoneyDaily(ctx context.Context, tx *sql.Tx, days []ageOfMoneyDay) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM age_of_money_daily"); err != nil {
+		return err
+	}
+	statement, err := tx.Prepare("INSERT INTO age_of_money_daily (date, age_of_money) VALUES (?, ?)")
+	if err != nil {
+		return err
+	}
+	for _, day := range days {
+		if _, err := statement.ExecContext(ctx, day.Date, day.AgeOfMoney); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ageOfMoneyCommand(sqlite sqliteService, args []string) error {
+	flags := flag.NewFlagSet("age-of-money", flag.ExitOnError)
+	window := flags.Int("window", ageOfMoneyWindow, "number of outflows to average")
+	show := flags.Int("days", 30, "number of days to print, 0 prints all")
+	flags.Parse(args)
+
+	return sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		flows, err := loadBudgetFlows(ctx, tx)
+		if err != nil {
+			return err
+		}
+		days := ageOfMoney(flows, *window)
+		if err := updateAgeOfMoneyDaily(ctx, tx, days); err != nil {
+			return err
+		}
+
+		if *show > 0 && len(days) > *show {
+			days = days[len(days)-*show:]
+		}
+		for _, day := range days {
+			fmt.Printf("%s\t%d\n", day.Date, day.AgeOfMoney)
+		}
+		return nil
+	})
+}
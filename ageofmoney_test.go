@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAgeOfMoney(t *testing.T) {
+	flows := []budgetFlow{
+		{date(t, "2022-01-01"), 1000000},
+		{date(t, "2022-01-11"), -400000},
+		{date(t, "2022-01-13"), 1000000},
+		// 600 from January 1st (20 days) and 200 from January 13th (8 days)
+		{date(t, "2022-01-21"), -800000},
+	}
+
+	days := ageOfMoney(flows, 2)
+	assertInt(t, "len(days)", len(days), 11)
+	assertValue(t, "days[0]", days[0], ageOfMoneyDay{"2022-01-11", 10})
+	assertValue(t, "days[5]", days[5], ageOfMoneyDay{"2022-01-16", 10})
+	assertValue(t, "days[10]", days[10], ageOfMoneyDay{"2022-01-21", 14})
+
+	days = ageOfMoney(flows, 1)
+	assertValue(t, "days[10]", days[10], ageOfMoneyDay{"2022-01-21", 17})
+
+	// spending without any money in the budget has no age
+	if days := ageOfMoney([]budgetFlow{{date(t, "2022-01-01"), -1000}}, 10); len(days) != 0 {
+		t.Fatalf("ageOfMoney = %v, want none", days)
+	}
+}
+
+func TestLoadBudgetFlows(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	var accounts Accounts
+	loadFixture("./fixtures/accounts.json", &accounts, t)
+	if err := updateAccounts(ctx, accounts, tx); err != nil {
+		t.Fatalf("updateAccounts err = %s, want nil", err)
+	}
+	mustExec(ctx, tx, t, `INSERT INTO "transaction" (id, date, amount, account_id, transfer_account_id, deleted) VALUES
+		('1', '2022-01-02', -50000, '9a329f5e-1eca-40c6-8ba1-a19b0d8cadd1', NULL, 0),
+		('2', '2022-01-02', 200000, '9a329f5e-1eca-40c6-8ba1-a19b0d8cadd1', NULL, 0),
+		('3', '2022-01-03', -100000, '9a329f5e-1eca-40c6-8ba1-a19b0d8cadd1', '95d0b9ce-2c8d-436c-b239-590aa963e547', 0),
+		('4', '2022-01-03', 100000, '95d0b9ce-2c8d-436c-b239-590aa963e547', '9a329f5e-1eca-40c6-8ba1-a19b0d8cadd1', 0)`)
+
+	flows, err := loadBudgetFlows(ctx, tx)
+	if err != nil {
+		t.Fatalf("loadBudgetFlows err = %s, want nil", err)
+	}
+	want := []budgetFlow{{date(t, "2022-01-02"), 200000}, {date(t, "2022-01-02"), -50000}}
+	if !reflect.DeepEqual(flows, want) {
+		t.Fatalf("loadBudgetFlows = %v, want %v", flows, want)
+	}
+
+	if err := updateAgeOfMoneyDaily(ctx, tx, ageOfMoney(flows, ageOfMoneyWindow)); err != nil {
+		t.Fatalf("updateAgeOfMoneyDaily err = %s, want nil", err)
+	}
+	assertValue(t, "age_of_money", queryString(ctx, tx, "SELECT date || ' ' || age_of_money FROM age_of_money_daily", t), "2022-01-02 0")
+}
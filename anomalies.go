@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// anomaly is a month or transaction of a category that deviates from the
+// rolling baseline of the category
+type anomaly struct {
+	Kind          string  `json:"kind"` // month or transaction
+	Month         string  `json:"month"`
+	Date          string  `json:"date,omitempty"`
+	TransactionID string  `json:"transaction_id,omitempty"`
+	CategoryID    string  `json:"category_id"`
+	CategoryName  string  `json:"category_name"`
+	PayeeName     string  `json:"payee_name,omitempty"`
+	Amount        int     `json:"amount"`   // milliunits
+	Baseline      int     `json:"baseline"` // mean or median of the history, milliunits
+	Score         float64 `json:"score"`
+}
+
+type anomalyOptions struct {
+	Method      string  // zscore or mad
+	Sensitivity float64 // minimum absolute score
+	Window      int     // months of history
+	MinHistory  int     // minimum number of values in the window
+	Since       string  // only report anomalies from this month on, YYYY-MM-01
+	Until       string  // ignore the activity of this month and later ones, YYYY-MM-01
+}
+
+// observation is a value of a category at a point in time
+type observation struct {
+	Date          string
+	TransactionID string
+	PayeeName     string
+	Amount        int
+}
+
+// anomalyScore rates value against history. The mad method uses the modified
+// z-score based on the median absolute deviation, which isn't distorted by
+// the outliers it is supposed to find. ok is false if the history has no
+// spread.
+func anomalyScore(method string, history []float64, value float64) (score float64, baseline float64, ok bool) {
+	switch method {
+	case "zscore":
+		var sum float64
+		for _, v := range history {
+			sum += v
+		}
+		mean := sum / float64(len(history))
+		var squares float64
+		for _, v := range history {
+			squares += (v - mean) * (v - mean)
+		}
+		std := math.Sqrt(squares / float64(len(history)))
+		if std == 0 {
+			return 0, mean, false
+		}
+		return (value - mean) / std, mean, true
+	default:
+		m := median(history)
+		deviations := make([]float64, len(history))
+		for i, v := range history {
+			deviations[i] = math.Abs(v - m)
+		}
+		mad := median(deviations)
+		if mad == 0 {
+			return 0, m, false
+		}
+		return 0.6745 * (value - m) / mad, m, true
+	}
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// detectAnomalies compares every observation with the observations of the
+// preceding window months. Observations have to be ordered by date.
+func detectAnomalies(observations []observation, options anomalyOptions) []anomaly {
+	var anomalies []anomaly
+	for i, o := range observations {
+		if o.Date < options.Since {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", o.Date)
+		if err != nil {
+			continue
+		}
+		windowStart := date.AddDate(0, -options.Window, 0).Format("2006-01-02")
+
+		var history []float64
+		for _, previous := range observations[:i] {
+			if previous.Date >= windowStart && previous.Date < o.Date {
+				history = append(history, float64(previous.Amount))
+			}
+		}
+		if len(history) < options.MinHistory {
+			continue
+		}
+
+		score, baseline, ok := anomalyScore(options.Method, history, float64(o.Amount))
+		if !ok || math.Abs(score) < options.Sensitivity {
+			continue
+		}
+		anomalies = append(anomalies, anomaly{
+			Month:         o.Date[:7] + "-01",
+			Date:          o.Date,
+			TransactionID: o.TransactionID,
+			PayeeName:     o.PayeeName,
+			Amount:        o.Amount,
+			Baseline:      int(math.Round(baseline)),
+			Score:         math.Round(score*100) / 100,
+		})
+	}
+	return anomalies
+}
+
+type categoryObservations struct {
+	CategoryID   string
+	CategoryName string
+	Observations []observation
+}
+
+// loadObservations runs query, which has to return category id, category
+// name, date, transaction id, payee name and amount ordered by category and
+// date, and groups the rows by category.
+func loadObservations(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]categoryObservations, error) {
+	res, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var categories []categoryObservations
+	for res.Next() {
+		var categoryID, categoryName string
+		var o observation
+		if err := res.Scan(&categoryID, &categoryName, &o.Date, &o.TransactionID, &o.PayeeName, &o.Amount); err != nil {
+			return nil, err
+		}
+		if len(categories) == 0 || categories[len(categories)-1].CategoryID != categoryID {
+			categories = append(categories, categoryObservations{CategoryID: categoryID, CategoryName: categoryName})
+		}
+		last := &categories[len(categories)-1]
+		last.Observations = append(last.Observations, o)
+	}
+	return categories, res.Err()
+}
+
+func findAnomalies(ctx context.Context, tx *sql.Tx, options anomalyOptions) ([]anomaly, error) {
+	months, err := loadObservations(ctx, tx, `
+		SELECT cm.category_id, c.name, cm.month_id, '', '', cm.activity
+		FROM category_month cm
+		JOIN category c ON c.id = cm.category_id
+		WHERE c.deleted <> 1
+		AND (?1 = '' OR cm.month_id < ?1)
+		ORDER BY cm.category_id, cm.month_id`, options.Until)
+	if err != nil {
+		return nil, err
+	}
+	transactions, err := loadObservations(ctx, tx, `
+		SELECT t.category_id, IFNULL(t.category_name, ''), t.date, t.id, IFNULL(t.payee_name, ''), t.amount
+		FROM "transaction" t
+		WHERE t.deleted <> 1
+		AND IFNULL(t.category_id, '') <> ''
+		AND IFNULL(t.transfer_account_id, '') = ''
+		AND NOT EXISTS(SELECT 1 FROM subtransaction s WHERE s.transaction_id = t.id AND s.deleted <> 1)
+		ORDER BY t.category_id, t.date, t.id`)
+	if err != nil {
+		return nil, err
+	}
+
+	var anomalies []anomaly
+	for kind, categories := range map[string][]categoryObservations{"month": months, "transaction": transactions} {
+		for _, category := range categories {
+			for _, a := range detectAnomalies(category.Observations, options) {
+				a.Kind = kind
+				a.CategoryID = category.CategoryID
+				a.CategoryName = category.CategoryName
+				if kind == "month" {
+					a.Date = ""
+				}
+				anomalies = append(anomalies, a)
+			}
+		}
+	}
+	sort.SliceStable(anomalies, func(i, j int) bool {
+		if anomalies[i].Month != anomalies[j].Month {
+			return anomalies[i].Month < anomalies[j].Month
+		}
+		if anomalies[i].Kind != anomalies[j].Kind {
+			return anomalies[i].Kind < anomalies[j].Kind
+		}
+		if math.Abs(anomalies[i].Score) != math.Abs(anomalies[j].Score) {
+			return math.Abs(anomalies[i].Score) > math.Abs(anomalies[j].Score)
+		}
+		return anomalies[i].CategoryName < anomalies[j].CategoryName
+	})
+	return anomalies, nil
+}
+
+func anomaliesCommand(sqlite sqliteService, args []string) error {
+	options := anomalyOptions{}
+	flags := flag.NewFlagSet("anomalies", flag.ExitOnError)
+	flags.StringVar(&options.Method, "method", "mad", "scoring method, mad (median absolute deviation) or zscore")
+	flags.Float64Var(&options.Sensitivity, "sensitivity", 3.5, "minimum absolute score of an anomaly, lower values report more")
+	flags.IntVar(&options.Window, "window", 6, "months of history that form the baseline")
+	flags.IntVar(&options.MinHistory, "min-history", 3, "minimum number of values in the baseline")
+	since := flags.String("since", "", "only report anomalies from this month on (YYYY-MM)")
+	current := flags.Bool("current", false, "include the activity of the current month, which is not complete yet")
+	format := flags.String("format", "table", "output format, table or json")
+	flags.Parse(args)
+
+	// the current month is still running and later months only contain
+	// budgeted amounts, their activity looks like a drop in spending
+	month, _ := time.Parse("2006-01", time.Now().Format("2006-01"))
+	if *current {
+		month = month.AddDate(0, 1, 0)
+	}
+	options.Until = month.Format("2006-01-02")
+
+	if options.Method != "mad" && options.Method != "zscore" {
+		return fmt.Errorf("unknown method %q", options.Method)
+	}
+	if *since != "" {
+		var err error
+		if options.Since, err = parseMonth(*since); err != nil {
+			return err
+		}
+	}
+
+	var anomalies []anomaly
+	err := sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		anomalies, err = findAnomalies(ctx, tx, options)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(anomalies)
+	case "table":
+		for _, a := range anomalies {
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\tbaseline %s\tscore %+.1f\t%s\n",
+				a.Month[:7], a.Kind, a.Date, a.CategoryName, formatAmount(a.Amount),
+				formatAmount(a.Baseline), a.Score, a.PayeeName)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAnomalyScore(t *testing.T) {
+	history := []float64{-100, -110, -90, -105, -95}
+
+	score, baseline, ok := anomalyScore("mad", history, -300)
+	if !ok {
+		t.Fatal("anomalyScore ok = false, want true")
+	}
+	if baseline != -100 {
+		t.Fatalf("baseline = %f, want -100", baseline)
+	}
+	if score > -3.5 {
+		t.Fatalf("score = %f, want < -3.5", score)
+	}
+
+	score, baseline, ok = anomalyScore("zscore", history, -100)
+	if !ok || score != 0 || baseline != -100 {
+		t.Fatalf("anomalyScore = %f, %f, %v, want 0, -100, true", score, baseline, ok)
+	}
+
+	if _, _, ok := anomalyScore("mad", []float64{-100, -100, -100}, -300); ok {
+		t.Fatal("anomalyScore ok = true for history without spread, want false")
+	}
+}
+
+func TestDetectAnomalies(t *testing.T) {
+	var observations []observation
+	for i, amount := range []int{-100000, -110000, -95000, -105000, -98000, -350000, -102000} {
+		observations = append(observations, observation{Date: fmt.Sprintf("2022-%02d-01", i+1), Amount: amount})
+	}
+	options := anomalyOptions{Method: "mad", Sensitivity: 3.5, Window: 6, MinHistory: 3}
+
+	anomalies := detectAnomalies(observations, options)
+	assertInt(t, "len(anomalies)", len(anomalies), 1)
+	assertValue(t, "Month", anomalies[0].Month, "2022-06-01")
+	assertInt(t, "Amount", anomalies[0].Amount, -350000)
+	assertInt(t, "Baseline", anomalies[0].Baseline, -100000)
+
+	options.Since = "2022-07-01"
+	assertInt(t, "len(anomalies)", len(detectAnomalies(observations, options)), 0)
+}
+
+func TestFindAnomalies(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	var categories Categories
+	loadFixture("./fixtures/categories.json", &categories, t)
+	if err := updateCategories(ctx, categories, tx); err != nil {
+		t.Fatalf("updateCategories err = %s, want nil", err)
+	}
+	groceries := "cb0d2e60-8d90-40d8-a3c6-f4963d27bc26"
+	for i, amount := range []int{-40000, -42000, -38000, -41000, -39000, -250000} {
+		mustExec(ctx, tx, t, `INSERT INTO "transaction" (id, date, amount, deleted, payee_name, category_id, category_name)
+			VALUES (?, ?, ?, 0, 'REWE', ?, 'Groceries')`, fmt.Sprintf("t%d", i), fmt.Sprintf("2022-03-%02d", i+1), amount, groceries)
+		mustExec(ctx, tx, t, `INSERT INTO category_month (month_id, category_id, activity) VALUES (?, ?, ?)`,
+			fmt.Sprintf("2022-%02d-01", i+1), groceries, amount*4)
+	}
+
+	// the running month and budgeted future months have no activity yet
+	mustExec(ctx, tx, t, `INSERT INTO category_month (month_id, category_id, activity) VALUES ('2022-07-01', ?, 0), ('2022-08-01', ?, 0)`,
+		groceries, groceries)
+
+	anomalies, err := findAnomalies(ctx, tx, anomalyOptions{Method: "mad", Sensitivity: 3.5, Window: 6, MinHistory: 3, Until: "2022-07-01"})
+	if err != nil {
+		t.Fatalf("findAnomalies err = %s, want nil", err)
+	}
+	assertInt(t, "len(anomalies)", len(anomalies), 2)
+	assertValue(t, "Kind", anomalies[0].Kind, "transaction")
+	assertValue(t, "TransactionID", anomalies[0].TransactionID, "t5")
+	assertValue(t, "Date", anomalies[0].Date, "2022-03-06")
+	assertValue(t, "Kind", anomalies[1].Kind, "month")
+	assertValue(t, "Month", anomalies[1].Month, "2022-06-01")
+	assertValue(t, "CategoryName", anomalies[1].CategoryName, "Groceries")
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// budgetEntry is a requested budgeted amount as given on the command line or
+// in a CSV file
+type budgetEntry struct {
+	Month    string // YYYY-MM-01
+	Category string // name or id
+	Budgeted int    // milliunits
+}
+
+// budgetChange compares a budgetEntry with the budgeted amount stored in
+// category_month
+type budgetChange struct {
+	Month        string
+	CategoryID   string
+	CategoryName string
+	Current      int
+	Budgeted     int
+}
+
+func budgetCommand(sqlite sqliteService, store storage, args []string) error {
+	if len(args) == 0 || args[0] != "set" {
+		return fmt.Errorf("usage: budget set [-dry-run] (MONTH CATEGORY AMOUNT | -file FILE)")
+	}
+
+	flags := flag.NewFlagSet("budget set", flag.ExitOnError)
+	file := flags.String("file", "", "csv file with the columns month,category,amount")
+	dryRun := flags.Bool("dry-run", false, "only show the changes")
+	flags.Parse(args[1:])
+
+	var entries []budgetEntry
+	var err error
+	switch {
+	case *file != "":
+		entries, err = readBudgetFile(*file)
+	case flags.NArg() == 3:
+		var entry budgetEntry
+		entry, err = parseBudgetEntry(flags.Arg(0), flags.Arg(1), flags.Arg(2))
+		entries = append(entries, entry)
+	default:
+		err = fmt.Errorf("usage: budget set [-dry-run] (MONTH CATEGORY AMOUNT | -file FILE)")
+	}
+	if err != nil {
+		return err
+	}
+
+	var changes []budgetChange
+	err = sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		changes, err = loadBudgetChanges(ctx, tx, entries)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		log.Print("budget is already up to date")
+		return nil
+	}
+	for _, change := range changes {
+		fmt.Printf("%s\t%s\t%s -> %s\t(%+.2f)\n",
+			change.Month[:7], change.CategoryName,
+			formatAmount(change.Current), formatAmount(change.Budgeted),
+			float64(change.Budgeted-change.Current)/1000)
+	}
+	if *dryRun {
+		return nil
+	}
+
+	ynab := ynabFromEnv()
+	applied, err := applyBudgetChanges(ynab, changes)
+	if err != nil && applied == 0 {
+		return err
+	}
+	if err != nil {
+		// the changes before the failure are in YNAB, sync them anyway
+		var names []string
+		for _, change := range changes[:applied] {
+			names = append(names, change.Month[:7]+" "+change.CategoryName)
+		}
+		log.Printf("updated %d of %d categories before the failure: %s", applied, len(changes), strings.Join(names, ", "))
+		if syncErr := syncBudget(ynab, store); syncErr != nil {
+			log.Print(syncErr)
+		}
+		return err
+	}
+
+	return syncBudget(ynab, store)
+}
+
+// applyBudgetChanges sends the changes to YNAB one after the other until one
+// fails. It returns the number of changes that were applied.
+func applyBudgetChanges(ynab YNAB, changes []budgetChange) (int, error) {
+	for i, change := range changes {
+		if _, err := ynab.UpdateMonthCategory(context.Background(), change.Month, change.CategoryID, change.Budgeted); err != nil {
+			return i, fmt.Errorf("could not update %s in %s: %s", change.CategoryName, change.Month, err)
+		}
+	}
+	return len(changes), nil
+}
+
+func parseBudgetEntry(month string, category string, amount string) (budgetEntry, error) {
+	entry := budgetEntry{Category: strings.TrimSpace(category)}
+	var err error
+	if entry.Month, err = parseMonth(month); err != nil {
+		return entry, err
+	}
+	if strings.TrimSpace(amount) == "" {
+		return entry, fmt.Errorf("missing amount for %s in %s", entry.Category, entry.Month[:7])
+	}
+	entry.Budgeted, err = parseAmount(amount, ".")
+	return entry, err
+}
+
+// parseMonth accepts YYYY-MM and YYYY-MM-DD and returns the first day of the
+// month, which is how YNAB identifies months.
+func parseMonth(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{"2006-01", "2006-01-02"} {
+		if month, err := time.Parse(layout, value); err == nil {
+			return month.Format("2006-01") + "-01", nil
+		}
+	}
+	return "", fmt.Errorf("could not parse month %q", value)
+}
+
+func readBudgetFile(path string) ([]budgetEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readBudgetCSV(file)
+}
+
+// readBudgetCSV reads month,category,amount rows. A header row is skipped.
+func readBudgetCSV(r io.Reader) ([]budgetEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 && strings.EqualFold(strings.TrimSpace(records[0][0]), "month") {
+		records = records[1:]
+	}
+
+	var entries []budgetEntry
+	for _, record := range records {
+		entry, err := parseBudgetEntry(record[0], record[1], record[2])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// findCategory resolves a category name or id using the category table.
+func findCategory(ctx context.Context, tx *sql.Tx, nameOrID string) (string, string, error) {
+	res, err := tx.QueryContext(ctx,
+		"SELECT id, name FROM category WHERE (id = ? OR name = ?) AND deleted <> 1",
+		nameOrID, nameOrID)
+	if err != nil {
+		return "", "", err
+	}
+	defer res.Close()
+
+	var ids, names []string
+	for res.Next() {
+		var id, name string
+		if err := res.Scan(&id, &name); err != nil {
+			return "", "", err
+		}
+		ids = append(ids, id)
+		names = append(names, name)
+	}
+	if err := res.Err(); err != nil {
+		return "", "", err
+	}
+
+	switch len(ids) {
+	case 0:
+		return "", "", fmt.Errorf("category %q not found, run a sync first", nameOrID)
+	case 1:
+		return ids[0], names[0], nil
+	default:
+		return "", "", fmt.Errorf("category name %q is ambiguous, use the category id", nameOrID)
+	}
+}
+
+// loadBudgetChanges validates the entries against the category table and
+// returns the entries that differ from the budgeted amount in category_month.
+func loadBudgetChanges(ctx context.Context, tx *sql.Tx, entries []budgetEntry) ([]budgetChange, error) {
+	var changes []budgetChange
+	for _, entry := range entries {
+		id, name, err := findCategory(ctx, tx, entry.Category)
+		if err != nil {
+			return nil, err
+		}
+
+		change := budgetChange{Month: entry.Month, CategoryID: id, CategoryName: name, Budgeted: entry.Budgeted}
+		err = tx.QueryRowContext(ctx,
+			"SELECT budgeted FROM category_month WHERE month_id = ? AND category_id = ?",
+			entry.Month, id).Scan(&change.Current)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		if change.Current != change.Budgeted {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMonth(t *testing.T) {
+	for value, want := range map[string]string{
+		"2022-12":    "2022-12-01",
+		"2022-12-24": "2022-12-01",
+	} {
+		got, err := parseMonth(value)
+		if err != nil {
+			t.Fatalf("parseMonth(%q) err = %s, want nil", value, err)
+		}
+		if got != want {
+			t.Fatalf("parseMonth(%q) = %q, want %q", value, got, want)
+		}
+	}
+	if _, err := parseMonth("December"); err == nil {
+		t.Fatal("parseMonth err = nil, want error")
+	}
+}
+
+func TestReadBudgetCSV(t *testing.T) {
+	entries, err := readBudgetCSV(strings.NewReader("month,category,amount\n2022-12,Water,50\n2023-01,Internet,39.99\n"))
+	if err != nil {
+		t.Fatalf("readBudgetCSV err = %s, want nil", err)
+	}
+	want := []budgetEntry{
+		{Month: "2022-12-01", Category: "Water", Budgeted: 50000},
+		{Month: "2023-01-01", Category: "Internet", Budgeted: 39990},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Fatalf("readBudgetCSV = %v, want %v", entries, want)
+	}
+}
+
+func TestLoadBudgetChanges(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	var categories Categories
+	loadFixture("./fixtures/categories.json", &categories, t)
+	if err := updateCategories(ctx, categories, tx); err != nil {
+		t.Fatalf("updateCategories err = %s, want nil", err)
+	}
+	mustExec(ctx, tx, t, `INSERT INTO category_month (month_id, category_id, budgeted, activity, balance)
+		VALUES ('2022-12-01', '7d3b19a3-a347-4a10-befc-b966f278aa3e', 50000, 0, 50000)`)
+
+	changes, err := loadBudgetChanges(ctx, tx, []budgetEntry{
+		{Month: "2022-12-01", Category: "Water", Budgeted: 50000},
+		{Month: "2022-12-01", Category: "cb19a998-9264-4255-a63c-349c586caeed", Budgeted: 40000},
+	})
+	if err != nil {
+		t.Fatalf("loadBudgetChanges err = %s, want nil", err)
+	}
+	want := []budgetChange{
+		{Month: "2022-12-01", CategoryID: "cb19a998-9264-4255-a63c-349c586caeed", CategoryName: "Internet", Current: 0, Budgeted: 40000},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("loadBudgetChanges = %v, want %v", changes, want)
+	}
+
+	_, err = loadBudgetChanges(ctx, tx, []budgetEntry{{Month: "2022-12-01", Category: "Unknown"}})
+	if err == nil {
+		t.Fatal("loadBudgetChanges err = nil, want error")
+	}
+}
+
+func TestParseBudgetEntryWithoutAmount(t *testing.T) {
+	if _, err := parseBudgetEntry("2022-12", "Groceries", " "); err == nil {
+		t.Fatal("parseBudgetEntry err = nil, want error")
+	}
+}
+
+func TestApplyBudgetChanges(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if strings.HasSuffix(r.URL.Path, "/categories/internet") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	applied, err := applyBudgetChanges(NewYNAB(ts.URL, "token", "last-used"), []budgetChange{
+		{Month: "2022-12-01", CategoryID: "water", CategoryName: "Water", Budgeted: 50000},
+		{Month: "2022-12-01", CategoryID: "internet", CategoryName: "Internet", Budgeted: 40000},
+		{Month: "2022-12-01", CategoryID: "rent", CategoryName: "Rent", Budgeted: 800000},
+	})
+	if err == nil || !strings.Contains(err.Error(), "Internet") {
+		t.Fatalf("applyBudgetChanges err = %v, want the error of Internet", err)
+	}
+	assertInt(t, "applied", applied, 1)
+	assertInt(t, "requests", requests, 2)
+}
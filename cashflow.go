@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"math"
+)
+
+// internalMasterCategory is the group of the category of income that is
+// available for budgeting and of the category of uncategorized transactions.
+// YNAB uses this name in every budget, the name of the income category
+// differs ("Inflow: Ready to Assign", formerly "Inflow: To be Budgeted").
+const internalMasterCategory = "Internal Master Category"
+
+// budgetLines is a common table expression of the ledger lines of on-budget
+// accounts. Transfers between on-budget accounts are left out, transfers to
+// tracking accounts are kept. Lines categorized as income to assign have
+// income set.
+const budgetLines = `budget_line AS (
+	SELECT l.transaction_id, IFNULL(l.subtransaction_id, '') AS subtransaction_id, l.date, l.amount,
+		l.category_id, l.category_name, l.category_group_name, l.payee_name, l.account_name,
+		IFNULL(l.category_group_name = '` + internalMasterCategory + `' AND l.category_name <> 'Uncategorized', 0) AS income
+	FROM ledger l
+	LEFT JOIN account transfer ON transfer.id = l.transfer_account_id
+	WHERE l.on_budget = 1 AND (NOT l.transfer OR IFNULL(transfer.on_budget, 0) <> 1)
+)`
+
+// cashflowReport shows income, expenses and the savings rate of the on-budget
+// accounts per month. Split transactions are counted per subtransaction.
+// Transfers between on-budget accounts don't change the cash flow and are
+// left out, transfers to tracking accounts are expenses (or income when the
+// money comes back), just like YNAB counts them.
+func cashflowReport(ctx context.Context, tx *sql.Tx, args []string) (report, string, error) {
+	flags := flag.NewFlagSet("report cashflow", flag.ExitOnError)
+	monthRange := monthRangeFlags(flags)
+	format := flags.String("format", "table", "output format, table, csv, json or markdown")
+	flags.Parse(args)
+
+	from, to, err := monthRange()
+	if err != nil {
+		return report{}, "", err
+	}
+
+	res, err := tx.QueryContext(ctx, `
+		WITH `+budgetLines+`
+		SELECT
+			substr(date, 1, 7) || '-01' AS month,
+			SUM(CASE WHEN income THEN amount ELSE 0 END),
+			SUM(CASE WHEN income THEN 0 ELSE amount END)
+		FROM budget_line
+		GROUP BY month
+		HAVING (? = '' OR month >= ?) AND (? = '' OR month <= ?)
+		ORDER BY month`,
+		from, from, to, to)
+	if err != nil {
+		return report{}, "", err
+	}
+	defer res.Close()
+
+	result := report{Columns: []string{"month", "income", "expenses", "net", "savings_rate"}}
+	for res.Next() {
+		var month string
+		var income, spending int
+		if err := res.Scan(&month, &income, &spending); err != nil {
+			return report{}, "", err
+		}
+		expenses := -spending
+		var savingsRate interface{}
+		if income > 0 {
+			savingsRate = math.Round(float64(income-expenses)/float64(income)*1000) / 10
+		}
+		result.add(month[:7], amount(income), amount(expenses), amount(income-expenses), savingsRate)
+	}
+	if err := res.Err(); err != nil {
+		return report{}, "", err
+	}
+
+	return result, *format, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCashflowReport(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	checking := "9a329f5e-1eca-40c6-8ba1-a19b0d8cadd1"
+	visa := "95d0b9ce-2c8d-436c-b239-590aa963e547"
+	var accounts Accounts
+	loadFixture("./fixtures/accounts.json", &accounts, t)
+	if err := updateAccounts(ctx, accounts, tx); err != nil {
+		t.Fatalf("updateAccounts err = %s, want nil", err)
+	}
+	mustExec(ctx, tx, t, `INSERT INTO account (id, name, type, on_budget, closed, deleted) VALUES ('mortgage', 'House', 'mortgage', 0, 0, 0)`)
+	// income is found by the category group, the name of the category differs in older budgets
+	mustExec(ctx, tx, t, `INSERT INTO category_group (id, name, hidden, deleted) VALUES ('internal', ?, 0, 0)`, internalMasterCategory)
+	mustExec(ctx, tx, t, `INSERT INTO category (id, category_group_id, name, hidden, deleted) VALUES
+		('inflow', 'internal', 'Inflow: To be Budgeted', 0, 0), ('uncategorized', 'internal', 'Uncategorized', 0, 0)`)
+	for _, row := range []struct {
+		id, date, account, category, transfer string
+		amount                                int
+	}{
+		{"salary", "2022-01-01", checking, "Inflow: To be Budgeted", "", 3000000},
+		{"groceries", "2022-01-03", checking, "Groceries", "", -500000},
+		// paying the credit card moves money between budget accounts
+		{"payment", "2022-01-05", checking, "", visa, -200000},
+		{"payment-visa", "2022-01-05", visa, "", checking, 200000},
+		// paying the mortgage moves money out of the budget
+		{"mortgage", "2022-01-06", checking, "Mortgage", "mortgage", -1000000},
+		{"mortgage-house", "2022-01-06", "mortgage", "", checking, 1000000},
+		{"split", "2022-01-10", checking, "", "", -300000},
+		{"refund", "2022-02-02", visa, "Groceries", "", 50000},
+		{"cash", "2022-02-03", checking, "Uncategorized", "", -20000},
+	} {
+		mustExec(ctx, tx, t, `INSERT INTO "transaction" (id, date, amount, account_id, category_id, category_name, transfer_account_id, deleted)
+			VALUES (?1, ?2, ?3, ?4, (SELECT id FROM category WHERE name = ?5), NULLIF(?5, ''), NULLIF(?6, ''), 0)`,
+			row.id, row.date, row.amount, row.account, row.category, row.transfer)
+	}
+	mustExec(ctx, tx, t, `INSERT INTO subtransaction (id, transaction_id, amount, category_name, transfer_account_id, deleted) VALUES
+		('split-1', 'split', -100000, 'Groceries', NULL, 0),
+		('split-2', 'split', -200000, NULL, ?, 0)`, visa)
+
+	r, _, err := cashflowReport(ctx, tx, nil)
+	if err != nil {
+		t.Fatalf("cashflowReport err = %s, want nil", err)
+	}
+	var buffer bytes.Buffer
+	r.write(&buffer, "csv")
+	want := "month,income,expenses,net,savings_rate\n" +
+		"2022-01,3000.00,1600.00,1400.00,46.7\n" +
+		"2022-02,0.00,-30.00,30.00,\n"
+	if got := buffer.String(); got != want {
+		t.Fatalf("cashflowReport = %q, want %q", got, want)
+	}
+
+	r, _, err = cashflowReport(ctx, tx, []string{"-from", "2022-02"})
+	if err != nil {
+		t.Fatalf("cashflowReport err = %s, want nil", err)
+	}
+	assertInt(t, "len(r.Rows)", len(r.Rows), 1)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// changeLog records every change of the rows of a table in change_log with
+// the row as JSON before and after the change
+type changeLog struct {
+	table  string
+	keys   int // number of primary key columns
+	row    *sql.Stmt
+	insert *sql.Stmt
+}
+
+// newChangeLog prepares the change log of table. keys are the primary key
+// columns, they have to be the first arguments of the statements passed to
+// exec.
+func newChangeLog(ctx context.Context, tx *sql.Tx, table string, keys ...string) (*changeLog, error) {
+	res, err := tx.QueryContext(ctx, "SELECT name FROM pragma_table_info(?) ORDER BY cid", table)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	var pairs []string
+	for res.Next() {
+		var column string
+		if err := res.Scan(&column); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, fmt.Sprintf(`'%s', "%s"`, column, column))
+	}
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+
+	var conditions []string
+	for _, key := range keys {
+		conditions = append(conditions, fmt.Sprintf(`"%s" = ?`, key))
+	}
+	row, err := tx.PrepareContext(ctx, fmt.Sprintf(`SELECT json_object(%s) FROM "%s" WHERE %s`,
+		strings.Join(pairs, ", "), table, strings.Join(conditions, " AND ")))
+	if err != nil {
+		return nil, err
+	}
+	insert, err := tx.PrepareContext(ctx, `
+		INSERT INTO change_log (
+			sync_run, changed_at, table_name, primary_key, operation, old_value, new_value
+		) VALUES (
+			(SELECT id FROM sync_run WHERE finished_at IS NULL ORDER BY id DESC LIMIT 1),
+			?, ?, ?, ?, ?, ?
+		)`)
+	if err != nil {
+		return nil, err
+	}
+	return &changeLog{table: table, keys: len(keys), row: row, insert: insert}, nil
+}
+
+func (c *changeLog) load(ctx context.Context, key []interface{}) (sql.NullString, error) {
+	var value sql.NullString
+	err := c.row.QueryRowContext(ctx, key...).Scan(&value)
+	if err == sql.ErrNoRows {
+		return value, nil
+	}
+	return value, err
+}
+
+// exec runs an upsert statement and records the change of the row, unless
+// the row stayed the same.
+func (c *changeLog) exec(ctx context.Context, statement *sql.Stmt, args ...interface{}) error {
+	key := args[:c.keys]
+	old, err := c.load(ctx, key)
+	if err != nil {
+		return err
+	}
+	if _, err := statement.ExecContext(ctx, args...); err != nil {
+		return err
+	}
+	new, err := c.load(ctx, key)
+	if err != nil {
+		return err
+	}
+	if old == new {
+		return nil
+	}
+
+	operation := "update"
+	if !old.Valid {
+		operation = "insert"
+	} else if deleted(new.String) && !deleted(old.String) {
+		operation = "delete"
+	}
+	var keyValues []string
+	for _, value := range key {
+		keyValues = append(keyValues, fmt.Sprint(value))
+	}
+	_, err = c.insert.ExecContext(ctx, time.Now().UTC().Format(time.RFC3339), c.table,
+		strings.Join(keyValues, "/"), operation, old, new)
+	return err
+}
+
+// deleted reports if the row has a truthy deleted column
+func deleted(row string) bool {
+	var values struct {
+		Deleted interface{} `json:"deleted"`
+	}
+	json.Unmarshal([]byte(row), &values)
+	return values.Deleted == float64(1) || values.Deleted == true
+}
+
+// startSyncRun adds a sync run that the changes of the transaction belong to
+// until finishSyncRun is called.
+func startSyncRun(ctx context.Context, tx *sql.Tx) (int64, error) {
+	res, err := tx.ExecContext(ctx, "INSERT INTO sync_run (started_at) VALUES (?)", time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func finishSyncRun(ctx context.Context, tx *sql.Tx, id int64) error {
+	_, err := tx.ExecContext(ctx, "UPDATE sync_run SET finished_at = ? WHERE id = ?", time.Now().UTC().Format(time.RFC3339), id)
+	return err
+}
+
+// change is a row of change_log
+type change struct {
+	Sequence   int64           `json:"sequence"`
+	SyncRun    *int64          `json:"sync_run"`
+	ChangedAt  string          `json:"changed_at"`
+	Table      string          `json:"table"`
+	PrimaryKey string          `json:"primary_key"`
+	Operation  string          `json:"operation"` // insert, update or delete
+	Old        json.RawMessage `json:"old"`
+	New        json.RawMessage `json:"new"`
+}
+
+func loadChanges(ctx context.Context, tx *sql.Tx, since int64, limit int) ([]change, error) {
+	res, err := tx.QueryContext(ctx, `
+		SELECT sequence, sync_run, changed_at, table_name, primary_key, operation,
+			IFNULL(old_value, 'null'), IFNULL(new_value, 'null')
+		FROM change_log
+		WHERE sequence > ?
+		ORDER BY sequence
+		LIMIT ?`,
+		since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var changes []change
+	for res.Next() {
+		var c change
+		var old, new string
+		if err := res.Scan(&c.Sequence, &c.SyncRun, &c.ChangedAt, &c.Table, &c.PrimaryKey, &c.Operation, &old, &new); err != nil {
+			return nil, err
+		}
+		c.Old, c.New = json.RawMessage(old), json.RawMessage(new)
+		changes = append(changes, c)
+	}
+	return changes, res.Err()
+}
+
+// changesCommand prints the changes after a sequence number as JSON lines.
+// With -follow it keeps polling for new changes.
+func changesCommand(sqlite sqliteService, args []string) error {
+	flags := flag.NewFlagSet("changes", flag.ExitOnError)
+	since := flags.Int64("since", 0, "print changes with a higher sequence number")
+	follow := flags.Bool("follow", false, "keep waiting for new changes")
+	interval := flags.Duration("interval", 5*time.Second, "polling interval with -follow")
+	flags.Parse(args)
+
+	encoder := json.NewEncoder(os.Stdout)
+	for {
+		var changes []change
+		err := sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+			var err error
+			changes, err = loadChanges(ctx, tx, *since, 1000)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, c := range changes {
+			if err := encoder.Encode(c); err != nil {
+				return err
+			}
+			*since = c.Sequence
+		}
+		if len(changes) == 1000 {
+			continue
+		}
+		if !*follow {
+			return nil
+		}
+		time.Sleep(*interval)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestChangeLog(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	run, err := startSyncRun(ctx, tx)
+	if err != nil {
+		t.Fatalf("startSyncRun err = %s, want nil", err)
+	}
+	var accounts Accounts
+	loadFixture("./fixtures/accounts.json", &accounts, t)
+	for i := 0; i < 2; i++ {
+		// the second update changes nothing
+		if err := updateAccounts(ctx, accounts, tx); err != nil {
+			t.Fatalf("updateAccounts err = %s, want nil", err)
+		}
+	}
+	accounts.Data.Accounts[0].ClearedBalance += 1000
+	accounts.Data.Accounts[1].Deleted = true
+	if err := updateAccounts(ctx, accounts, tx); err != nil {
+		t.Fatalf("updateAccounts err = %s, want nil", err)
+	}
+	if err := finishSyncRun(ctx, tx, run); err != nil {
+		t.Fatalf("finishSyncRun err = %s, want nil", err)
+	}
+
+	var categoryMonth CategoryMonth
+	categoryMonth.Data.Month.Month.Month = "2022-12-01"
+	categoryMonth.Data.Month.Categories = []category{{ID: "water", Balance: 500}}
+	if err := updateCategoryMonth(ctx, categoryMonth, tx); err != nil {
+		t.Fatalf("updateCategoryMonth err = %s, want nil", err)
+	}
+
+	changes, err := loadChanges(ctx, tx, 0, 100)
+	if err != nil {
+		t.Fatalf("loadChanges err = %s, want nil", err)
+	}
+	assertInt(t, "len(changes)", len(changes), 5)
+	for i, want := range []string{"insert", "insert", "update", "delete", "insert"} {
+		assertValue(t, "Operation", changes[i].Operation, want)
+	}
+	assertValue(t, "Table", changes[2].Table, "account")
+	assertValue(t, "PrimaryKey", changes[2].PrimaryKey, accounts.Data.Accounts[0].ID)
+	if changes[2].SyncRun == nil || *changes[2].SyncRun != run {
+		t.Fatalf("SyncRun = %v, want %d", changes[2].SyncRun, run)
+	}
+	if string(changes[0].Old) != "null" || string(changes[2].Old) == string(changes[2].New) {
+		t.Fatalf("old = %s, new = %s, want the account before and after", changes[2].Old, changes[2].New)
+	}
+	// changes outside of a sync don't belong to a run
+	if changes[4].SyncRun != nil {
+		t.Fatalf("SyncRun = %d, want nil", *changes[4].SyncRun)
+	}
+	assertValue(t, "PrimaryKey", changes[4].PrimaryKey, "2022-12-01/water")
+
+	changes, err = loadChanges(ctx, tx, changes[3].Sequence, 100)
+	if err != nil {
+		t.Fatalf("loadChanges err = %s, want nil", err)
+	}
+	assertInt(t, "len(changes)", len(changes), 1)
+}
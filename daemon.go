@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+type daemonOptions struct {
+	Interval     time.Duration
+	Jitter       float64       // relative random deviation of the interval
+	MinRemaining int           // minimum number of remaining API requests to sync
+	MaxBackoff   time.Duration // longest wait after failures or when the rate limit is low
+}
+
+// heartbeat is the row of the daemon in the heartbeat table. Monitors can
+// detect a stalled daemon by a next_sync_at that is long overdue.
+type heartbeat struct {
+	Name          string
+	Status        string // starting, syncing, waiting or stopped
+	UpdatedAt     time.Time
+	LastSuccessAt time.Time
+	LastError     string
+	NextSyncAt    time.Time
+}
+
+func updateHeartbeat(ctx context.Context, tx *sql.Tx, beat heartbeat) error {
+	formatTime := func(t time.Time) interface{} {
+		if t.IsZero() {
+			return nil
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO heartbeat (name, pid, status, updated_at, last_success_at, last_error, next_sync_at)
+		VALUES (?, ?, ?, ?, ?, NULLIF(?, ''), ?)
+		ON CONFLICT(name) DO UPDATE SET
+			pid=excluded.pid,
+			status=excluded.status,
+			updated_at=excluded.updated_at,
+			last_success_at=excluded.last_success_at,
+			last_error=excluded.last_error,
+			next_sync_at=excluded.next_sync_at`,
+		beat.Name, os.Getpid(), beat.Status, formatTime(beat.UpdatedAt), formatTime(beat.LastSuccessAt),
+		beat.LastError, formatTime(beat.NextSyncAt))
+	return err
+}
+
+// nextSyncDelay returns the time until the next sync. Every consecutive
+// failure doubles the interval. When less than MinRemaining requests are
+// left, the daemon waits MaxBackoff, the rate limit is reset after an hour.
+// random is a number between 0 and 1 that spreads the delay by the jitter.
+func nextSyncDelay(options daemonOptions, failures int, remaining int, remainingKnown bool, random float64) time.Duration {
+	delay := options.Interval
+	for i := 0; i < failures && delay < options.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if remainingKnown && remaining < options.MinRemaining {
+		delay = options.MaxBackoff
+	}
+	if delay > options.MaxBackoff {
+		delay = options.MaxBackoff
+	}
+	return time.Duration(float64(delay) * (1 + options.Jitter*(2*random-1)))
+}
+
+// syncSafely runs a sync and turns panics of the API client into errors, so
+// a failed request doesn't stop the daemon.
+func syncSafely(ctx context.Context, ynab YNAB, store storage) (result syncResult, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("%v", p)
+		}
+	}()
+	return syncBudgetContext(ctx, ynab, store)
+}
+
+// runDaemon syncs into store until stop is closed. A sync that is running
+// when stop is closed is finished, cancelling ctx rolls it back instead. The
+// heartbeat is written to the SQLite database and the results of the syncs
+// are recorded in metrics, which may be nil.
+func runDaemon(ctx context.Context, stop <-chan struct{}, ynab YNAB, sqlite sqliteService, store storage, options daemonOptions, metrics *syncMetrics) error {
+	beat := heartbeat{Name: "daemon", Status: "starting"}
+	writeHeartbeat := func() {
+		beat.UpdatedAt = time.Now()
+		err := sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+			return updateHeartbeat(ctx, tx, beat)
+		})
+		if err != nil {
+			log.Printf("could not write heartbeat: %s", err)
+		}
+	}
+
+	failures := 0
+	for {
+		beat.Status = "syncing"
+		writeHeartbeat()
+
+		started := time.Now()
+		result, err := syncSafely(ctx, ynab, store)
+		metrics.record(time.Since(started), result.Rows, err)
+		if err != nil {
+			failures++
+			beat.LastError = err.Error()
+			log.Printf("sync failed (%d in a row): %s", failures, err)
+		} else {
+			failures = 0
+			beat.LastSuccessAt = time.Now()
+			beat.LastError = ""
+			log.Printf("sync finished in %s", time.Since(started).Round(time.Millisecond))
+		}
+
+		remaining, known := ynab.RemainingRequests()
+		delay := nextSyncDelay(options, failures, remaining, known, rand.Float64())
+		if known && remaining < options.MinRemaining {
+			log.Printf("only %d requests left, waiting %s", remaining, delay.Round(time.Second))
+		}
+		beat.Status = "waiting"
+		beat.NextSyncAt = time.Now().Add(delay)
+		writeHeartbeat()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+			continue
+		case <-stop:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+		beat.Status = "stopped"
+		beat.NextSyncAt = time.Time{}
+		writeHeartbeat()
+		return nil
+	}
+}
+
+func daemonCommand(sqlite sqliteService, store storage, args []string) error {
+	options := daemonOptions{}
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	flags.DurationVar(&options.Interval, "interval", 15*time.Minute, "time between syncs")
+	flags.Float64Var(&options.Jitter, "jitter", 0.1, "random deviation of the interval, 0.1 means +/- 10%")
+	flags.IntVar(&options.MinRemaining, "min-remaining", 20, "wait for the rate limit to reset when fewer requests are left")
+	flags.DurationVar(&options.MaxBackoff, "max-backoff", time.Hour, "longest wait after failed syncs")
+	listen := flags.String("listen", "", "address of the Prometheus metrics endpoint, e.g. :9090")
+	flags.Parse(args)
+
+	if options.Interval <= 0 || options.Jitter < 0 || options.Jitter >= 1 {
+		return fmt.Errorf("the interval has to be positive and the jitter between 0 and 1")
+	}
+	if options.MaxBackoff < options.Interval {
+		options.MaxBackoff = options.Interval
+	}
+
+	// the first signal stops after the running sync, the second one rolls
+	// the running sync back
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		log.Print("stopping after the running sync, send another signal to abort it")
+		close(stop)
+		<-signals
+		log.Print("aborting the running sync")
+		cancel()
+	}()
+
+	ynab := ynabFromEnv()
+	metrics := newSyncMetrics()
+	if *listen != "" {
+		server := newMetricsServer(*listen, metricsHandler(sqlite, ynab, metrics))
+		go func() {
+			log.Printf("serving metrics on %s/metrics", *listen)
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("metrics server failed: %s", err)
+			}
+		}()
+		defer server.Close()
+	}
+
+	return runDaemon(ctx, stop, ynab, sqlite, store, options, metrics)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// prepareFileDB uses a database file, an in-memory database is lost with
+// the connection a cancelled transaction closes.
+func prepareFileDB(t *testing.T) (*sql.DB, sqliteService) {
+	t.Helper()
+	db, err := openSqlite(filepath.Join(t.TempDir(), "database.db"))
+	if err != nil {
+		t.Fatal("failed to open db")
+	}
+	sqlite := NewSqliteService(db)
+	if err := sqlite.CreateTables(); err != nil {
+		t.Fatalf("createTables err = %s, want nil", err)
+	}
+	return db, sqlite
+}
+
+func TestNextSyncDelay(t *testing.T) {
+	options := daemonOptions{Interval: 10 * time.Minute, Jitter: 0.1, MinRemaining: 20, MaxBackoff: time.Hour}
+
+	tests := []struct {
+		name           string
+		failures       int
+		remaining      int
+		remainingKnown bool
+		random         float64
+		want           time.Duration
+	}{
+		{"interval", 0, 150, true, 0.5, 10 * time.Minute},
+		{"jitter", 0, 150, true, 0, 9 * time.Minute},
+		{"unknown rate limit", 0, 0, false, 1, 11 * time.Minute},
+		{"backoff", 2, 150, true, 0.5, 40 * time.Minute},
+		{"max backoff", 5, 150, true, 0.5, time.Hour},
+		{"rate limit", 0, 10, true, 0.5, time.Hour},
+	}
+	for _, test := range tests {
+		if got := nextSyncDelay(options, test.failures, test.remaining, test.remainingKnown, test.random); got != test.want {
+			t.Fatalf("%s: nextSyncDelay = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRemainingRequests(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Rate-Limit", "36/200")
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	ynab := NewYNAB(ts.URL, "token", "last-used")
+	if _, ok := ynab.RemainingRequests(); ok {
+		t.Fatal("RemainingRequests ok = true before the first request, want false")
+	}
+	if _, err := ynab.request(context.Background(), ts.URL); err != nil {
+		t.Fatalf("request err = %s, want nil", err)
+	}
+	remaining, ok := ynab.RemainingRequests()
+	if !ok {
+		t.Fatal("RemainingRequests ok = false, want true")
+	}
+	assertInt(t, "remaining", remaining, 164)
+
+	if _, _, ok := parseRateLimit("unknown"); ok {
+		t.Fatal(`parseRateLimit("unknown") ok = true, want false`)
+	}
+}
+
+func TestTransactionContextRollback(t *testing.T) {
+	db, sqlite := prepareFileDB(t)
+	defer db.Close()
+
+	insert := func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO payee (id, name) VALUES ('1', 'Hugo')")
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := sqlite.TransactionContext(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if err := insert(ctx, tx); err != nil {
+			return err
+		}
+		cancel()
+		return nil
+	})
+	if err == nil {
+		t.Fatal("TransactionContext err = nil after cancel, want error")
+	}
+
+	func() {
+		defer func() { recover() }()
+		sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+			insert(ctx, tx)
+			panic("failed request")
+		})
+	}()
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM payee").Scan(&count); err != nil {
+		t.Fatalf("failed to count payees: %s", err)
+	}
+	assertInt(t, "payees", count, 0)
+}
+
+func TestRunDaemon(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Rate-Limit", "199/200")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	db, sqlite := prepareFileDB(t)
+	defer db.Close()
+
+	stop := make(chan struct{})
+	close(stop)
+	options := daemonOptions{Interval: time.Minute, MinRemaining: 20, MaxBackoff: time.Hour}
+	if err := runDaemon(context.Background(), stop, NewYNAB(ts.URL, "token", "last-used"), sqlite, sqlite, options, nil); err != nil {
+		t.Fatalf("runDaemon err = %s, want nil", err)
+	}
+
+	var status, lastError string
+	var lastSuccess sql.NullString
+	err := db.QueryRow("SELECT status, last_error, last_success_at FROM heartbeat WHERE name = 'daemon'").Scan(&status, &lastError, &lastSuccess)
+	if err != nil {
+		t.Fatalf("failed to query heartbeat: %v", err)
+	}
+	assertValue(t, "status", status, "stopped")
+	if lastError == "" || lastSuccess.Valid {
+		t.Fatalf("last_error = %q, last_success_at = %v, want the failed sync", lastError, lastSuccess)
+	}
+}
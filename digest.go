@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	_ "embed"
+	"flag"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed digest.txt.tmpl
+var digestText string
+
+//go:embed digest.html.tmpl
+var digestHTML string
+
+var (
+	digestTextTemplate = template.Must(template.New("digest").Parse(digestText))
+	digestHTMLTemplate = htmltemplate.Must(htmltemplate.New("digest").Parse(digestHTML))
+)
+
+// digestTransactions is the number of biggest transactions in a digest
+const digestTransactions = 5
+
+type digestGroup struct {
+	Name  string
+	Spent amount
+}
+
+type digestTransaction struct {
+	Date     string
+	Payee    string
+	Category string
+	Account  string
+	Amount   amount
+}
+
+type digestCategory struct {
+	Group    string
+	Category string
+	Balance  amount
+}
+
+type digestAccount struct {
+	Name     string
+	OnBudget bool
+	Balance  amount
+}
+
+// digest is a summary of the budget over a period. From is the first day of
+// the period, To the last one.
+type digest struct {
+	Period       string
+	From, To     string
+	Month        string
+	Spent        amount
+	Groups       []digestGroup
+	Transactions []digestTransaction
+	Overspent    []digestCategory
+	Unapproved   int
+	Accounts     []digestAccount
+}
+
+// digestPeriod returns the first and the last day of the period before now.
+// A week are the seven days before today, a month is the previous calendar
+// month.
+func digestPeriod(period string, now time.Time) (time.Time, time.Time, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case "weekly":
+		return today.AddDate(0, 0, -7), today.AddDate(0, 0, -1), nil
+	case "monthly":
+		first := today.AddDate(0, 0, 1-today.Day())
+		return first.AddDate(0, -1, 0), first.AddDate(0, 0, -1), nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("unknown period %q, use weekly or monthly", period)
+	}
+}
+
+// buildDigest summarizes the period before now. Spending is counted like in
+// the cash flow report, overspent categories are those of the month the
+// period ends in. The unapproved transactions and account balances are
+// current.
+func buildDigest(ctx context.Context, tx *sql.Tx, period string, now time.Time) (digest, error) {
+	from, to, err := digestPeriod(period, now)
+	if err != nil {
+		return digest{}, err
+	}
+	result := digest{
+		Period: period,
+		From:   from.Format("2006-01-02"),
+		To:     to.Format("2006-01-02"),
+		Month:  to.Format("2006-01") + "-01",
+	}
+
+	res, err := tx.QueryContext(ctx, `
+		WITH `+budgetLines+`
+		SELECT IFNULL(category_group_name, 'Uncategorized'), -SUM(amount) AS spent
+		FROM budget_line
+		WHERE date >= ? AND date <= ? AND NOT income
+		GROUP BY 1
+		HAVING spent <> 0
+		ORDER BY spent DESC, 1`,
+		result.From, result.To)
+	if err != nil {
+		return digest{}, err
+	}
+	defer res.Close()
+	for res.Next() {
+		var group digestGroup
+		if err := res.Scan(&group.Name, &group.Spent); err != nil {
+			return digest{}, err
+		}
+		result.Spent += group.Spent
+		result.Groups = append(result.Groups, group)
+	}
+	if err := res.Err(); err != nil {
+		return digest{}, err
+	}
+
+	res, err = tx.QueryContext(ctx, `
+		WITH `+budgetLines+`
+		SELECT date, IFNULL(payee_name, ''), IFNULL(category_name, ''), IFNULL(account_name, ''), amount
+		FROM budget_line
+		WHERE date >= ? AND date <= ? AND amount < 0
+		ORDER BY amount, date
+		LIMIT ?`,
+		result.From, result.To, digestTransactions)
+	if err != nil {
+		return digest{}, err
+	}
+	defer res.Close()
+	for res.Next() {
+		var t digestTransaction
+		if err := res.Scan(&t.Date, &t.Payee, &t.Category, &t.Account, &t.Amount); err != nil {
+			return digest{}, err
+		}
+		result.Transactions = append(result.Transactions, t)
+	}
+	if err := res.Err(); err != nil {
+		return digest{}, err
+	}
+
+	res, err = tx.QueryContext(ctx, `
+		SELECT g.name, c.name, cm.balance
+		FROM category_month cm
+		JOIN category c ON c.id = cm.category_id
+		JOIN category_group g ON g.id = c.category_group_id
+		WHERE cm.month_id = ? AND cm.balance < 0 AND c.deleted <> 1 AND g.deleted <> 1
+		ORDER BY cm.balance, g.name, c.name`,
+		result.Month)
+	if err != nil {
+		return digest{}, err
+	}
+	defer res.Close()
+	for res.Next() {
+		var category digestCategory
+		if err := res.Scan(&category.Group, &category.Category, &category.Balance); err != nil {
+			return digest{}, err
+		}
+		result.Overspent = append(result.Overspent, category)
+	}
+	if err := res.Err(); err != nil {
+		return digest{}, err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM "transaction"
+		WHERE deleted <> 1 AND IFNULL(approved, 0) <> 1`).Scan(&result.Unapproved)
+	if err != nil {
+		return digest{}, err
+	}
+
+	res, err = tx.QueryContext(ctx, `
+		SELECT IFNULL(name, ''), IFNULL(on_budget, 0), IFNULL(cleared_balance, 0) + IFNULL(uncleared_balane, 0)
+		FROM account
+		WHERE deleted <> 1 AND closed <> 1
+		ORDER BY on_budget DESC, name`)
+	if err != nil {
+		return digest{}, err
+	}
+	defer res.Close()
+	for res.Next() {
+		var account digestAccount
+		if err := res.Scan(&account.Name, &account.OnBudget, &account.Balance); err != nil {
+			return digest{}, err
+		}
+		result.Accounts = append(result.Accounts, account)
+	}
+	return result, res.Err()
+}
+
+// subject is the subject of the digest mail
+func (d digest) subject() string {
+	if d.Period == "monthly" {
+		return "Budget digest " + d.From[:7]
+	}
+	return fmt.Sprintf("Budget digest %s to %s", d.From, d.To)
+}
+
+// message renders the digest as multipart mail with a text and a HTML part
+func (d digest) message(from string, to []string) ([]byte, error) {
+	var text, html bytes.Buffer
+	if err := digestTextTemplate.Execute(&text, d); err != nil {
+		return nil, fmt.Errorf("could not render text digest: %s", err)
+	}
+	if err := digestHTMLTemplate.Execute(&html, d); err != nil {
+		return nil, fmt.Errorf("could not render HTML digest: %s", err)
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(w)
+		encoder.Write(part.content)
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", d.subject()))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+// sendDigest sends the digest through the SMTP server at address. The server
+// is logged into when SMTP_USERNAME is set.
+func sendDigest(address string, from string, to []string, d digest) error {
+	message, err := d.message(from, to)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if username, ok := os.LookupEnv("SMTP_USERNAME"); ok {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return fmt.Errorf("could not parse SMTP address: %s", err)
+		}
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	if err := smtp.SendMail(address, auth, from, to, message); err != nil {
+		return fmt.Errorf("could not send digest: %s", err)
+	}
+	return nil
+}
+
+func digestCommand(sqlite sqliteService, args []string) error {
+	flags := flag.NewFlagSet("digest", flag.ExitOnError)
+	period := flags.String("period", "weekly", "summarized period, weekly or monthly")
+	server := flags.String("smtp", "localhost:25", "address of the SMTP server")
+	from := flags.String("from", "", "sender of the mail")
+	to := flags.String("to", "", "comma separated recipients of the mail")
+	dryRun := flags.Bool("dry-run", false, "print the text digest instead of sending it")
+	flags.Parse(args)
+
+	var result digest
+	err := sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		result, err = buildDigest(ctx, tx, *period, time.Now())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		return digestTextTemplate.Execute(os.Stdout, result)
+	}
+	var recipients []string
+	for _, recipient := range strings.Split(*to, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+	if *from == "" || len(recipients) == 0 {
+		return fmt.Errorf("usage: digest -from SENDER -to RECIPIENTS [flags]")
+	}
+	return sendDigest(*server, *from, recipients, result)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDigestPeriod(t *testing.T) {
+	now := time.Date(2022, 3, 2, 8, 0, 0, 0, time.Local)
+	for _, test := range []struct {
+		period, from, to string
+	}{
+		{"weekly", "2022-02-23", "2022-03-01"},
+		{"monthly", "2022-02-01", "2022-02-28"},
+	} {
+		from, to, err := digestPeriod(test.period, now)
+		if err != nil {
+			t.Fatalf("digestPeriod(%q) err = %s, want nil", test.period, err)
+		}
+		assertValue(t, test.period+" from", from.Format("2006-01-02"), test.from)
+		assertValue(t, test.period+" to", to.Format("2006-01-02"), test.to)
+	}
+	if _, _, err := digestPeriod("daily", now); err == nil {
+		t.Fatalf("digestPeriod(daily) err = nil, want error")
+	}
+}
+
+func TestBuildDigest(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	mustExec(ctx, tx, t, `INSERT INTO account (id, name, type, on_budget, closed, deleted, cleared_balance, uncleared_balane) VALUES
+		('checking', 'Checking', 'checking', 1, 0, 0, 1500000, -20000),
+		('house', 'House', 'otherAsset', 0, 0, 0, 300000000, 0),
+		('old', 'Old', 'savings', 1, 1, 0, 0, 0)`)
+	mustExec(ctx, tx, t, `INSERT INTO category_group (id, name, hidden, deleted) VALUES
+		('internal', ?, 0, 0), ('bills', 'Bills', 0, 0), ('fun', 'Fun', 0, 0)`, internalMasterCategory)
+	mustExec(ctx, tx, t, `INSERT INTO category (id, category_group_id, name, hidden, deleted) VALUES
+		('inflow', 'internal', 'Inflow: Ready to Assign', 0, 0), ('rent', 'bills', 'Rent', 0, 0), ('games', 'fun', 'Games', 0, 0), ('dining', 'fun', 'Dining', 0, 0)`)
+	mustExec(ctx, tx, t, `INSERT INTO category_month (month_id, category_id, balance) VALUES
+		('2022-03-01', 'games', -15000), ('2022-03-01', 'rent', 0), ('2022-02-01', 'dining', -1000)`)
+	for _, row := range []struct {
+		id, date, category, payee string
+		amount                    int
+		approved                  bool
+	}{
+		{"salary", "2022-03-01", "inflow", "Employer", 2000000, true},
+		{"rent", "2022-03-01", "rent", "Landlord", -800000, true},
+		{"game", "2022-03-03", "games", "Shop", -60000, false},
+		{"refund", "2022-03-04", "games", "Shop", 10000, true},
+		{"dinner", "2022-03-05", "dining", "Diner", -40000, false},
+		{"before", "2022-02-28", "rent", "Landlord", -800000, true},
+	} {
+		mustExec(ctx, tx, t, `INSERT INTO "transaction" (id, date, amount, account_id, category_id, category_name, payee_name, approved, deleted)
+			SELECT ?, ?, ?, 'checking', ?4, (SELECT name FROM category WHERE id = ?4), ?, ?, 0`,
+			row.id, row.date, row.amount, row.category, row.payee, row.approved)
+	}
+
+	d, err := buildDigest(ctx, tx, "weekly", time.Date(2022, 3, 8, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("buildDigest err = %s, want nil", err)
+	}
+	assertValue(t, "From", d.From, "2022-03-01")
+	assertValue(t, "To", d.To, "2022-03-07")
+	assertInt(t, "Spent", int(d.Spent), 890000)
+	if want := []digestGroup{{"Bills", 800000}, {"Fun", 90000}}; !reflect.DeepEqual(d.Groups, want) {
+		t.Fatalf("Groups = %v, want %v", d.Groups, want)
+	}
+	if len(d.Transactions) != 3 || d.Transactions[0].Payee != "Landlord" || d.Transactions[2].Account != "Checking" {
+		t.Fatalf("Transactions = %v, want rent, game and dinner", d.Transactions)
+	}
+	if want := []digestCategory{{"Fun", "Games", -15000}}; !reflect.DeepEqual(d.Overspent, want) {
+		t.Fatalf("Overspent = %v, want %v", d.Overspent, want)
+	}
+	assertInt(t, "Unapproved", d.Unapproved, 2)
+	if want := []digestAccount{{"Checking", true, 1480000}, {"House", false, 300000000}}; !reflect.DeepEqual(d.Accounts, want) {
+		t.Fatalf("Accounts = %v, want %v", d.Accounts, want)
+	}
+}
+
+// fakeSMTP accepts a single mail on a local port and sends what it received
+// to the returned channel
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	received := make(chan string, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+		var transcript strings.Builder
+		reply("220 localhost ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			transcript.WriteString(line)
+			switch command := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case command == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					transcript.WriteString(strings.TrimPrefix(line, "."))
+				}
+				reply("250 queued")
+			case command == "QUIT":
+				reply("221 bye")
+				received <- transcript.String()
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestSendDigest(t *testing.T) {
+	address, received := fakeSMTP(t)
+	d := digest{
+		Period: "weekly", From: "2022-03-01", To: "2022-03-07", Month: "2022-03-01",
+		Spent:     800000,
+		Groups:    []digestGroup{{"Bills & Rent", 800000}},
+		Overspent: []digestCategory{{"Fun", "Games", -15000}},
+		Accounts:  []digestAccount{{"Checking", true, 1480000}},
+	}
+	if err := sendDigest(address, "budget@example.com", []string{"me@example.com"}, d); err != nil {
+		t.Fatalf("sendDigest err = %s, want nil", err)
+	}
+
+	var transcript string
+	select {
+	case transcript = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no mail received")
+	}
+	for _, want := range []string{"MAIL FROM:<budget@example.com>", "RCPT TO:<me@example.com>"} {
+		if !strings.Contains(transcript, want) {
+			t.Fatalf("transcript doesn't contain %q:\n%s", want, transcript)
+		}
+	}
+
+	message, err := mail.ReadMessage(strings.NewReader(transcript[strings.Index(transcript, "From: "):]))
+	if err != nil {
+		t.Fatalf("could not parse mail: %s", err)
+	}
+	assertValue(t, "Subject", message.Header.Get("Subject"), "Budget digest 2022-03-01 to 2022-03-07")
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, want multipart/alternative", message.Header.Get("Content-Type"))
+	}
+
+	parts := make(map[string]string)
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("could not read part: %s", err)
+		}
+		content, _ := io.ReadAll(part)
+		parts[strings.Split(part.Header.Get("Content-Type"), ";")[0]] = string(content)
+	}
+	for contentType, want := range map[string][]string{
+		"text/plain": {"Spent: 800.00", "Bills & Rent: 800.00", "Fun: Games  -15.00", "Checking: 1480.00"},
+		"text/html":  {"Bills &amp; Rent", "-15.00", "Unapproved transactions: 0"},
+	} {
+		for _, text := range want {
+			if !strings.Contains(parts[contentType], text) {
+				t.Fatalf("%s part doesn't contain %q:\n%s", contentType, text, parts[contentType])
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// duplicateTransaction is one side of a duplicate candidate
+type duplicateTransaction struct {
+	ID        string `json:"id"`
+	Date      string `json:"date"`
+	PayeeName string `json:"payee_name"`
+	ImportID  string `json:"import_id,omitempty"`
+}
+
+// duplicateCandidate is a pair of transactions that are probably the same
+// booking entered twice
+type duplicateCandidate struct {
+	AccountName string               `json:"account_name"`
+	Amount      int                  `json:"amount"` // milliunits
+	A           duplicateTransaction `json:"a"`
+	B           duplicateTransaction `json:"b"`
+	Confidence  float64              `json:"confidence"`
+}
+
+type duplicateOptions struct {
+	Window        int     // maximum number of days between the transactions
+	AllImports    bool    // also compare pairs that are both imported or both entered manually
+	MinConfidence float64 // minimum confidence of reported pairs
+}
+
+// duplicateConfidence rates a candidate pair by the similarity of the payees
+// and the number of days between the transactions.
+func duplicateConfidence(a duplicateTransaction, b duplicateTransaction, window int) float64 {
+	payee := payeeSimilarity(normalizePayeeName(a.PayeeName), normalizePayeeName(b.PayeeName))
+	date := 1 - float64(daysBetween(a.Date, b.Date))/float64(window+1)
+	return math.Round((0.6*payee+0.4*date)*100) / 100
+}
+
+// findDuplicates looks for transactions on the same account with the same
+// amount at most window days apart. Transfers and pairs that YNAB matched
+// already are skipped. Unless AllImports is set, only pairs of an imported
+// and a manually entered transaction are compared.
+func findDuplicates(ctx context.Context, tx *sql.Tx, options duplicateOptions) ([]duplicateCandidate, error) {
+	res, err := tx.QueryContext(ctx, `
+		SELECT
+			IFNULL(a.account_name, ''), a.amount,
+			a.id, a.date, IFNULL(a.payee_name, ''), IFNULL(a.import_id, ''),
+			b.id, b.date, IFNULL(b.payee_name, ''), IFNULL(b.import_id, '')
+		FROM "transaction" a
+		JOIN "transaction" b ON b.account_id = a.account_id AND b.amount = a.amount AND b.id > a.id
+		WHERE a.deleted <> 1 AND b.deleted <> 1
+		AND a.amount <> 0
+		AND abs(julianday(a.date) - julianday(b.date)) <= ?
+		AND IFNULL(a.transfer_account_id, '') = '' AND IFNULL(b.transfer_account_id, '') = ''
+		AND IFNULL(a.matched_transaction_id, '') <> b.id AND IFNULL(b.matched_transaction_id, '') <> a.id
+		AND (? OR (IFNULL(a.import_id, '') = '') <> (IFNULL(b.import_id, '') = ''))
+		ORDER BY a.date, a.id, b.id`,
+		options.Window, options.AllImports)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var candidates []duplicateCandidate
+	for res.Next() {
+		var c duplicateCandidate
+		err := res.Scan(&c.AccountName, &c.Amount,
+			&c.A.ID, &c.A.Date, &c.A.PayeeName, &c.A.ImportID,
+			&c.B.ID, &c.B.Date, &c.B.PayeeName, &c.B.ImportID)
+		if err != nil {
+			return nil, err
+		}
+		if c.Confidence = duplicateConfidence(c.A, c.B, options.Window); c.Confidence >= options.MinConfidence {
+			candidates = append(candidates, c)
+		}
+	}
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Confidence > candidates[j].Confidence })
+	return candidates, nil
+}
+
+func duplicatesCommand(sqlite sqliteService, args []string) error {
+	options := duplicateOptions{}
+	flags := flag.NewFlagSet("duplicates", flag.ExitOnError)
+	flags.IntVar(&options.Window, "window", 3, "maximum number of days between duplicates")
+	flags.BoolVar(&options.AllImports, "all", false, "also compare two imported or two manually entered transactions")
+	flags.Float64Var(&options.MinConfidence, "min-confidence", 0.5, "minimum confidence between 0 and 1")
+	format := flags.String("format", "table", "output format, table or json")
+	flags.Parse(args)
+
+	var candidates []duplicateCandidate
+	err := sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		candidates, err = findDuplicates(ctx, tx, options)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(candidates)
+	case "table":
+		for _, c := range candidates {
+			fmt.Printf("%.2f\t%s\t%s\t%s %s\t%s %s\t%s\t%s\n",
+				c.Confidence, c.AccountName, formatAmount(c.Amount),
+				c.A.Date, c.A.PayeeName, c.B.Date, c.B.PayeeName, c.A.ID, c.B.ID)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	for _, row := range []struct {
+		id, date, account, payee, importID, transfer, matched string
+		amount                                                int
+	}{
+		{"manual", "2022-01-03", "checking", "Netflix", "", "", "", -9990},
+		{"imported", "2022-01-04", "checking", "PAYPAL *NETFLIX", "YNAB:-9990:2022-01-04:1", "", "", -9990},
+		// a different account
+		{"visa", "2022-01-04", "visa", "Netflix", "", "", "", -9990},
+		// YNAB matched these already
+		{"hugo-manual", "2022-01-10", "checking", "Hugo", "", "", "hugo-imported", -23000},
+		{"hugo-imported", "2022-01-10", "checking", "Hugo", "YNAB:-23000:2022-01-10:1", "", "hugo-manual", -23000},
+		// too far apart
+		{"rent-1", "2022-01-01", "checking", "Rent", "", "", "", -800000},
+		{"rent-2", "2022-01-08", "checking", "Rent", "YNAB:-800000:2022-01-08:1", "", "", -800000},
+		// both imported
+		{"coffee-1", "2022-01-12", "checking", "Cafe", "YNAB:-3000:2022-01-12:1", "", "", -3000},
+		{"coffee-2", "2022-01-12", "checking", "Cafe", "YNAB:-3000:2022-01-12:2", "", "", -3000},
+		// transfers
+		{"transfer-1", "2022-01-15", "checking", "Transfer : Visa", "", "visa", "", -100000},
+		{"transfer-2", "2022-01-15", "checking", "Transfer : Visa", "YNAB:-100000:2022-01-15:1", "visa", "", -100000},
+	} {
+		mustExec(ctx, tx, t, `INSERT INTO "transaction" (id, date, amount, account_id, account_name, payee_name, import_id, transfer_account_id, matched_transaction_id, deleted)
+			VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), 0)`,
+			row.id, row.date, row.amount, row.account, row.account, row.payee, row.importID, row.transfer, row.matched)
+	}
+
+	ids := func(candidates []duplicateCandidate) []string {
+		var pairs []string
+		for _, c := range candidates {
+			pairs = append(pairs, c.A.ID+" "+c.B.ID)
+		}
+		return pairs
+	}
+
+	candidates, err := findDuplicates(ctx, tx, duplicateOptions{Window: 3, MinConfidence: 0.5})
+	if err != nil {
+		t.Fatalf("findDuplicates err = %s, want nil", err)
+	}
+	if got, want := ids(candidates), []string{"imported manual"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("findDuplicates = %v, want %v", got, want)
+	}
+	assertValue(t, "Confidence", candidates[0].Confidence, 0.9)
+	assertValue(t, "AccountName", candidates[0].AccountName, "checking")
+
+	candidates, err = findDuplicates(ctx, tx, duplicateOptions{Window: 3, AllImports: true, MinConfidence: 0.5})
+	if err != nil {
+		t.Fatalf("findDuplicates err = %s, want nil", err)
+	}
+	if got, want := ids(candidates), []string{"coffee-1 coffee-2", "imported manual"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("findDuplicates = %v, want %v", got, want)
+	}
+}
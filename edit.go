@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// transactionEdit describes changes to apply to transactions. Fields that are
+// nil stay unchanged.
+type transactionEdit struct {
+	CategoryID *string
+	PayeeName  *string
+	Memo       *string
+	FlagColor  *string
+	Approved   *bool
+	Cleared    *string
+}
+
+// editableTransaction is the part of a transaction row that can be changed
+// with a transactionEdit
+type editableTransaction struct {
+	ID           string
+	Date         string
+	Amount       int
+	AccountID    string
+	PayeeName    string
+	CategoryID   string
+	CategoryName string
+	Memo         string
+	FlagColor    string
+	Approved     bool
+	Cleared      string
+	Split        bool
+}
+
+type fieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// transactionChange is the change set of a single transaction
+type transactionChange struct {
+	Transaction editableTransaction
+	Changes     []fieldChange
+	Update      UpdateTransaction
+}
+
+// loadEditableTransactions selects transactions by a WHERE clause over the
+// transaction table. Deleted transactions are never selected.
+func loadEditableTransactions(ctx context.Context, tx *sql.Tx, where string, args ...interface{}) ([]editableTransaction, error) {
+	query := `
+		SELECT
+			t.id, t.date, t.amount, IFNULL(t.account_id, ''), IFNULL(t.payee_name, ''),
+			IFNULL(t.category_id, ''), IFNULL(t.category_name, ''),
+			IFNULL(t.memo, ''), IFNULL(t.flag_color, ''), t.approved, IFNULL(t.cleared, ''),
+			EXISTS(SELECT 1 FROM subtransaction s WHERE s.transaction_id = t.id AND s.deleted <> 1)
+		FROM "transaction" t
+		WHERE t.deleted <> 1 AND (` + where + `)
+		ORDER BY t.date, t.id`
+	res, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var transactions []editableTransaction
+	for res.Next() {
+		var t editableTransaction
+		err := res.Scan(&t.ID, &t.Date, &t.Amount, &t.AccountID, &t.PayeeName, &t.CategoryID, &t.CategoryName,
+			&t.Memo, &t.FlagColor, &t.Approved, &t.Cleared, &t.Split)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, res.Err()
+}
+
+// diffTransaction returns the change set that edit would cause on t. The
+// category of split transactions is left alone, it belongs to the
+// subtransactions.
+func diffTransaction(t editableTransaction, edit transactionEdit) transactionChange {
+	change := transactionChange{Transaction: t, Update: UpdateTransaction{ID: t.ID}}
+	compare := func(field string, old string, new *string, target **string) {
+		if new != nil && *new != old {
+			change.Changes = append(change.Changes, fieldChange{Field: field, Old: old, New: *new})
+			*target = new
+		}
+	}
+
+	if !t.Split {
+		compare("category_id", t.CategoryID, edit.CategoryID, &change.Update.CategoryID)
+	}
+	compare("payee_name", t.PayeeName, edit.PayeeName, &change.Update.PayeeName)
+	compare("memo", t.Memo, edit.Memo, &change.Update.Memo)
+	compare("flag_color", t.FlagColor, edit.FlagColor, &change.Update.FlagColor)
+	compare("cleared", t.Cleared, edit.Cleared, &change.Update.Cleared)
+	if edit.Approved != nil && *edit.Approved != t.Approved {
+		change.Changes = append(change.Changes, fieldChange{
+			Field: "approved",
+			Old:   strconv.FormatBool(t.Approved),
+			New:   strconv.FormatBool(*edit.Approved),
+		})
+		change.Update.Approved = edit.Approved
+	}
+	return change
+}
+
+func printTransactionChanges(changes []transactionChange, categoryNames map[string]string) {
+	for _, change := range changes {
+		t := change.Transaction
+		fmt.Printf("%s\t%s\t%s\t%s\n", t.ID, t.Date, formatAmount(t.Amount), t.PayeeName)
+		for _, field := range change.Changes {
+			old, new := field.Old, field.New
+			if field.Field == "category_id" {
+				old, new = categoryNames[old], categoryNames[new]
+			}
+			fmt.Printf("\t%s: %q -> %q\n", field.Field, old, new)
+		}
+	}
+}
+
+func loadCategoryNames(ctx context.Context, tx *sql.Tx) (map[string]string, error) {
+	names := make(map[string]string)
+	res, err := tx.QueryContext(ctx, "SELECT id, name FROM category")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	for res.Next() {
+		var id, name string
+		if err := res.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, res.Err()
+}
+
+// recordTransactionAudit stores the applied changes in transaction_audit.
+func recordTransactionAudit(ctx context.Context, tx *sql.Tx, source string, changes []transactionChange) error {
+	statement, err := tx.Prepare(`
+		INSERT INTO transaction_audit (
+			changed_at, source, transaction_id, field, old_value, new_value
+		) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	changedAt := time.Now().UTC().Format(time.RFC3339)
+	for _, change := range changes {
+		for _, field := range change.Changes {
+			_, err := statement.ExecContext(ctx, changedAt, source, change.Transaction.ID, field.Field, field.Old, field.New)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// savedTransactionChanges returns the changes of the transactions YNAB
+// reported as saved.
+func savedTransactionChanges(changes []transactionChange, saved SaveTransactions) []transactionChange {
+	ids := make(map[string]bool)
+	for _, id := range saved.Data.TransactionIDs {
+		ids[id] = true
+	}
+	var result []transactionChange
+	for _, change := range changes {
+		if ids[change.Transaction.ID] {
+			result = append(result, change)
+		}
+	}
+	return result
+}
+
+// applyTransactionChanges sends the change set to YNAB, records it in the
+// audit table and syncs the result back into store.
+func applyTransactionChanges(ynab YNAB, sqlite sqliteService, store storage, source string, changes []transactionChange) error {
+	var updates []UpdateTransaction
+	for _, change := range changes {
+		updates = append(updates, change.Update)
+	}
+	saved, err := ynab.UpdateTransactions(context.Background(), updates)
+	if err != nil {
+		return fmt.Errorf("could not update transactions: %s", err)
+	}
+	log.Printf("updated %d transactions", len(saved.Data.TransactionIDs))
+	applied := savedTransactionChanges(changes, saved)
+	if len(applied) < len(changes) {
+		log.Printf("YNAB did not save %d of the changed transactions", len(changes)-len(applied))
+	}
+
+	err = sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		return recordTransactionAudit(ctx, tx, source, applied)
+	})
+	if err != nil {
+		return fmt.Errorf("could not record audit: %s", err)
+	}
+
+	return syncBudget(ynab, store)
+}
+
+func txCommand(sqlite sqliteService, store storage, args []string) error {
+	if len(args) == 0 || args[0] != "update" {
+		return fmt.Errorf("usage: tx update -where CONDITION [flags]")
+	}
+
+	flags := flag.NewFlagSet("tx update", flag.ExitOnError)
+	where := flags.String("where", "", "raw SQL condition over the transaction table, e.g. \"payee_name LIKE 'Amazon%'\"")
+	category := flags.String("category", "", "new category name or id")
+	payee := flags.String("payee", "", "new payee name")
+	memo := flags.String("memo", "", "new memo")
+	flagColor := flags.String("flag", "", "new flag color (red, orange, yellow, green, blue, purple)")
+	approved := flags.String("approved", "", "approve (true) or unapprove (false)")
+	cleared := flags.String("cleared", "", "new cleared status (cleared, uncleared, reconciled)")
+	dryRun := flags.Bool("dry-run", false, "only show the changes")
+	flags.Parse(args[1:])
+
+	if *where == "" {
+		return fmt.Errorf("usage: tx update -where CONDITION [flags]")
+	}
+
+	var edit transactionEdit
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "payee":
+			edit.PayeeName = payee
+		case "memo":
+			edit.Memo = memo
+		case "flag":
+			edit.FlagColor = flagColor
+		case "cleared":
+			edit.Cleared = cleared
+		case "approved":
+			var value bool
+			if value, err = strconv.ParseBool(*approved); err == nil {
+				edit.Approved = &value
+			}
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("invalid value for -approved: %s", err)
+	}
+
+	var changes []transactionChange
+	var categoryNames map[string]string
+	err = sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		if *category != "" {
+			id, _, err := findCategory(ctx, tx, *category)
+			if err != nil {
+				return err
+			}
+			edit.CategoryID = &id
+		}
+
+		transactions, err := loadEditableTransactions(ctx, tx, *where)
+		if err != nil {
+			return fmt.Errorf("could not select transactions: %s", err)
+		}
+		for _, t := range transactions {
+			if t.Split && edit.CategoryID != nil {
+				log.Printf("skipping category change of split transaction %s", t.ID)
+			}
+			if change := diffTransaction(t, edit); len(change.Changes) > 0 {
+				changes = append(changes, change)
+			}
+		}
+
+		categoryNames, err = loadCategoryNames(ctx, tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		log.Print("no transactions to change")
+		return nil
+	}
+	printTransactionChanges(changes, categoryNames)
+	if *dryRun {
+		return nil
+	}
+
+	return applyTransactionChanges(ynabFromEnv(), sqlite, store, "tx update", changes)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoadEditableTransactions(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	var transactions Transactions
+	loadFixture("./fixtures/transactions.json", &transactions, t)
+	if err := updateTransactions(ctx, transactions, tx); err != nil {
+		t.Fatalf("updateTransactions err = %s, want nil", err)
+	}
+
+	got, err := loadEditableTransactions(ctx, tx, "payee_name = ?", "Hugo")
+	if err != nil {
+		t.Fatalf("loadEditableTransactions err = %s, want nil", err)
+	}
+	if len(got) == 0 {
+		t.Fatal("len(loadEditableTransactions) = 0, want > 0")
+	}
+	for _, transaction := range got {
+		assertValue(t, "PayeeName", transaction.PayeeName, "Hugo")
+	}
+
+	split, err := loadEditableTransactions(ctx, tx, "id = ?", "dcc9865c-dd45-468b-93c3-fa6b327db3fe_2021-11-25")
+	if err != nil {
+		t.Fatalf("loadEditableTransactions err = %s, want nil", err)
+	}
+	if len(split) != 1 || !split[0].Split {
+		t.Fatalf("loadEditableTransactions = %v, want one split transaction", split)
+	}
+
+	if _, err := loadEditableTransactions(ctx, tx, "no_such_column = 1"); err == nil {
+		t.Fatal("loadEditableTransactions err = nil, want error")
+	}
+}
+
+func TestDiffTransaction(t *testing.T) {
+	category, memo, approved := "new-category", "", true
+	transaction := editableTransaction{ID: "id", CategoryID: "old-category", Memo: "", Approved: false}
+
+	change := diffTransaction(transaction, transactionEdit{CategoryID: &category, Memo: &memo, Approved: &approved})
+	want := []fieldChange{
+		{Field: "category_id", Old: "old-category", New: "new-category"},
+		{Field: "approved", Old: "false", New: "true"},
+	}
+	if !reflect.DeepEqual(change.Changes, want) {
+		t.Fatalf("change.Changes = %v, want %v", change.Changes, want)
+	}
+	assertValue(t, "Update.CategoryID", *change.Update.CategoryID, "new-category")
+	assertNil(t, "Update.Memo", change.Update.Memo)
+
+	transaction.Split = true
+	change = diffTransaction(transaction, transactionEdit{CategoryID: &category})
+	if len(change.Changes) != 0 {
+		t.Fatalf("change.Changes = %v, want no changes for split transaction", change.Changes)
+	}
+}
+
+func TestRecordTransactionAudit(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	changes := []transactionChange{{
+		Transaction: editableTransaction{ID: "transaction-id"},
+		Changes:     []fieldChange{{Field: "memo", Old: "", New: "groceries"}},
+	}}
+	if err := recordTransactionAudit(ctx, tx, "tx update", changes); err != nil {
+		t.Fatalf("recordTransactionAudit err = %s, want nil", err)
+	}
+	got := queryString(ctx, tx, `SELECT new_value FROM transaction_audit WHERE transaction_id = 'transaction-id' AND field = 'memo'`, t)
+	if want := "groceries"; got != want {
+		t.Fatalf("%q != %q", got, want)
+	}
+}
+
+func TestSavedTransactionChanges(t *testing.T) {
+	changes := []transactionChange{
+		{Transaction: editableTransaction{ID: "saved"}},
+		{Transaction: editableTransaction{ID: "rejected"}},
+	}
+	var saved SaveTransactions
+	saved.Data.TransactionIDs = []string{"saved"}
+
+	got := savedTransactionChanges(changes, saved)
+	assertInt(t, "len(got)", len(got), 1)
+	assertValue(t, "got[0].Transaction.ID", got[0].Transaction.ID, "saved")
+}
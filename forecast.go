@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+type forecastAccount struct {
+	ID       string
+	Name     string
+	OnBudget bool
+	Balance  int // milliunits, cleared and uncleared
+}
+
+// forecastEvent is an expected future transaction
+type forecastEvent struct {
+	Date      time.Time
+	AccountID string
+	Amount    int
+	PayeeName string
+}
+
+type forecastDay struct {
+	Date     time.Time
+	Balances []int // in the order of the accounts
+	Total    int
+}
+
+// scheduledFrequencies advances a date by one interval of a scheduled
+// transaction frequency. twiceAMonth is approximated with 15 days.
+var scheduledFrequencies = map[string]func(time.Time) time.Time{
+	"daily":           func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+	"weekly":          func(t time.Time) time.Time { return t.AddDate(0, 0, 7) },
+	"everyOtherWeek":  func(t time.Time) time.Time { return t.AddDate(0, 0, 14) },
+	"twiceAMonth":     func(t time.Time) time.Time { return t.AddDate(0, 0, 15) },
+	"every4Weeks":     func(t time.Time) time.Time { return t.AddDate(0, 0, 28) },
+	"monthly":         func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+	"everyOtherMonth": func(t time.Time) time.Time { return t.AddDate(0, 2, 0) },
+	"every3Months":    func(t time.Time) time.Time { return t.AddDate(0, 3, 0) },
+	"every4Months":    func(t time.Time) time.Time { return t.AddDate(0, 4, 0) },
+	"twiceAYear":      func(t time.Time) time.Time { return t.AddDate(0, 6, 0) },
+	"yearly":          func(t time.Time) time.Time { return t.AddDate(1, 0, 0) },
+	"everyOtherYear":  func(t time.Time) time.Time { return t.AddDate(2, 0, 0) },
+}
+
+func loadForecastAccounts(ctx context.Context, tx *sql.Tx) ([]forecastAccount, error) {
+	res, err := tx.QueryContext(ctx, `
+		SELECT id, name, on_budget, IFNULL(cleared_balance, 0) + IFNULL(uncleared_balane, 0)
+		FROM account
+		WHERE deleted <> 1 AND closed <> 1
+		ORDER BY on_budget DESC, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var accounts []forecastAccount
+	for res.Next() {
+		var a forecastAccount
+		if err := res.Scan(&a.ID, &a.Name, &a.OnBudget, &a.Balance); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, res.Err()
+}
+
+// scheduledEvents expands the scheduled transactions until end. A schedule
+// that is overdue is expected once on start, the occurrences it missed before
+// start are skipped. Transfers also create the counterpart on the transfer
+// account.
+func scheduledEvents(ctx context.Context, tx *sql.Tx, start time.Time, end time.Time) ([]forecastEvent, error) {
+	res, err := tx.QueryContext(ctx, `
+		SELECT date_next, frequency, amount, account_id, IFNULL(transfer_account_id, ''), IFNULL(payee_name, '')
+		FROM scheduled_transaction
+		WHERE deleted <> 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var events []forecastEvent
+	for res.Next() {
+		var dateNext, frequency, transferAccountID string
+		var event forecastEvent
+		if err := res.Scan(&dateNext, &frequency, &event.Amount, &event.AccountID, &transferAccountID, &event.PayeeName); err != nil {
+			return nil, err
+		}
+		date, err := time.Parse("2006-01-02", dateNext)
+		if err != nil {
+			return nil, err
+		}
+
+		next := scheduledFrequencies[frequency]
+		for !date.After(end) {
+			event.Date = date
+			if event.Date.Before(start) {
+				event.Date = start
+			}
+			events = append(events, event)
+			if transferAccountID != "" {
+				events = append(events, forecastEvent{Date: event.Date, AccountID: transferAccountID, Amount: -event.Amount, PayeeName: event.PayeeName})
+			}
+			if next == nil {
+				break // "never" or unknown frequency
+			}
+			// skip the occurrences an overdue schedule missed before start
+			for date = next(date); !date.After(event.Date); date = next(date) {
+			}
+		}
+	}
+	return events, res.Err()
+}
+
+// recurringEvents expands detected recurring series until end. Series of a
+// payee and account that already have a scheduled transaction are skipped,
+// just like series that were missed, they are probably cancelled.
+func recurringEvents(series []recurringSeries, scheduled []forecastEvent, end time.Time) []forecastEvent {
+	covered := make(map[string]bool)
+	for _, event := range scheduled {
+		covered[event.AccountID+"\x00"+strings.ToLower(event.PayeeName)] = true
+	}
+
+	var events []forecastEvent
+	for _, s := range series {
+		if s.Missed || covered[s.AccountID+"\x00"+strings.ToLower(s.PayeeName)] {
+			continue
+		}
+		var p period
+		for _, candidate := range periods {
+			if candidate.Name == s.Period {
+				p = candidate
+			}
+		}
+		date, err := time.Parse("2006-01-02", s.NextDate)
+		if err != nil || p.next == nil {
+			continue
+		}
+		for ; !date.After(end); date = p.next(date) {
+			events = append(events, forecastEvent{Date: date, AccountID: s.AccountID, Amount: s.Amount, PayeeName: s.PayeeName})
+		}
+	}
+	return events
+}
+
+// forecastBalances applies the events day by day, starting with the current
+// account balances.
+func forecastBalances(accounts []forecastAccount, events []forecastEvent, start time.Time, days int) []forecastDay {
+	index := make(map[string]int)
+	balances := make([]int, len(accounts))
+	for i, account := range accounts {
+		index[account.ID] = i
+		balances[i] = account.Balance
+	}
+
+	byDate := make(map[string][]forecastEvent)
+	for _, event := range events {
+		key := event.Date.Format("2006-01-02")
+		byDate[key] = append(byDate[key], event)
+	}
+
+	var forecast []forecastDay
+	for d := 0; d <= days; d++ {
+		date := start.AddDate(0, 0, d)
+		for _, event := range byDate[date.Format("2006-01-02")] {
+			if i, ok := index[event.AccountID]; ok {
+				balances[i] += event.Amount
+			}
+		}
+
+		day := forecastDay{Date: date, Balances: append([]int(nil), balances...)}
+		for _, balance := range balances {
+			day.Total += balance
+		}
+		forecast = append(forecast, day)
+	}
+	return forecast
+}
+
+// negativeBalanceWarnings reports on-budget accounts that start with a
+// positive balance and are projected to go below zero. Accounts that are
+// negative already, like credit cards, are not reported.
+func negativeBalanceWarnings(accounts []forecastAccount, forecast []forecastDay) []string {
+	var warnings []string
+	for i, account := range accounts {
+		if !account.OnBudget || account.Balance < 0 {
+			continue
+		}
+		for _, day := range forecast {
+			if day.Balances[i] < 0 {
+				warnings = append(warnings, fmt.Sprintf("%s is projected to go negative on %s (%s)",
+					account.Name, day.Date.Format("2006-01-02"), formatAmount(day.Balances[i])))
+				break
+			}
+		}
+	}
+	return warnings
+}
+
+func forecastCommand(sqlite sqliteService, args []string) error {
+	flags := flag.NewFlagSet("forecast", flag.ExitOnError)
+	days := flags.Int("days", 30, "number of days to forecast")
+	useRecurring := flags.Bool("recurring", true, "include recurring transactions detected in the history")
+	flags.Parse(args)
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	end := today.AddDate(0, 0, *days)
+
+	var accounts []forecastAccount
+	var events []forecastEvent
+	err := sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		if accounts, err = loadForecastAccounts(ctx, tx); err != nil {
+			return err
+		}
+		if events, err = scheduledEvents(ctx, tx, today, end); err != nil {
+			return err
+		}
+		if *useRecurring {
+			occurrences, err := loadOccurrences(ctx, tx)
+			if err != nil {
+				return err
+			}
+			series := detectRecurring(occurrences, defaultRecurringOptions(), today)
+			events = append(events, recurringEvents(series, events, end)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	forecast := forecastBalances(accounts, events, today, *days)
+
+	header := []string{"date"}
+	for _, account := range accounts {
+		header = append(header, account.Name)
+	}
+	fmt.Println(strings.Join(append(header, "total"), "\t"))
+	for _, day := range forecast {
+		row := []string{day.Date.Format("2006-01-02")}
+		for _, balance := range day.Balances {
+			row = append(row, formatAmount(balance))
+		}
+		fmt.Println(strings.Join(append(row, formatAmount(day.Total)), "\t"))
+	}
+
+	for _, warning := range negativeBalanceWarnings(accounts, forecast) {
+		log.Printf("warning: %s", warning)
+	}
+	return nil
+}
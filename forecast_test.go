@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func date(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		t.Fatalf("failed to parse date %q", value)
+	}
+	return parsed
+}
+
+func TestScheduledEvents(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	var scheduledTransactions ScheduledTransactions
+	loadFixture("./fixtures/scheduled_transactions.json", &scheduledTransactions, t)
+	if err := updateScheduledTransactions(ctx, scheduledTransactions, tx); err != nil {
+		t.Fatalf("updateScheduledTransactions err = %s, want nil", err)
+	}
+
+	events, err := scheduledEvents(ctx, tx, date(t, "2021-12-10"), date(t, "2022-01-20"))
+	if err != nil {
+		t.Fatalf("scheduledEvents err = %s, want nil", err)
+	}
+	var got []string
+	for _, event := range events {
+		got = append(got, event.Date.Format("2006-01-02")+" "+formatAmount(event.Amount))
+	}
+	want := []string{
+		// overdue water bill is expected today
+		"2021-12-10 -23.00", "2022-01-01 -23.00",
+		// transfers create both sides
+		"2021-12-15 -50.00", "2021-12-15 50.00", "2022-01-15 -50.00", "2022-01-15 50.00",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("scheduledEvents = %v, want %v", got, want)
+	}
+}
+
+func TestScheduledEventsOverdue(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	var scheduledTransactions ScheduledTransactions
+	loadFixture("./fixtures/scheduled_transactions.json", &scheduledTransactions, t)
+	if err := updateScheduledTransactions(ctx, scheduledTransactions, tx); err != nil {
+		t.Fatalf("updateScheduledTransactions err = %s, want nil", err)
+	}
+	mustExec(ctx, tx, t, `DELETE FROM scheduled_transaction WHERE id = '7f3e1c2b-5a9d-4c8e-8f1a-2b3c4d5e6f70'`)
+
+	// the monthly water bill is overdue since December
+	events, err := scheduledEvents(ctx, tx, date(t, "2022-03-10"), date(t, "2022-04-20"))
+	if err != nil {
+		t.Fatalf("scheduledEvents err = %s, want nil", err)
+	}
+	var got []string
+	for _, event := range events {
+		got = append(got, event.Date.Format("2006-01-02")+" "+formatAmount(event.Amount))
+	}
+	want := []string{"2022-03-10 -23.00", "2022-04-01 -23.00"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("scheduledEvents = %v, want %v", got, want)
+	}
+}
+
+func TestRecurringEvents(t *testing.T) {
+	series := []recurringSeries{
+		{PayeeName: "Spotify", AccountID: "checking", Period: "monthly", Amount: -9990, NextDate: "2022-01-03"},
+		{PayeeName: "Gym", AccountID: "checking", Period: "weekly", Amount: -5000, NextDate: "2021-12-01", Missed: true},
+		{PayeeName: "Hugo", AccountID: "checking", Period: "monthly", Amount: -23000, NextDate: "2022-01-01"},
+	}
+	scheduled := []forecastEvent{{AccountID: "checking", PayeeName: "hugo"}}
+
+	events := recurringEvents(series, scheduled, date(t, "2022-02-28"))
+	assertInt(t, "len(events)", len(events), 2)
+	assertValue(t, "events[1].Date", events[1].Date.Format("2006-01-02"), "2022-02-03")
+}
+
+func TestForecastBalances(t *testing.T) {
+	accounts := []forecastAccount{
+		{ID: "checking", Name: "Checking", OnBudget: true, Balance: 100000},
+		{ID: "visa", Name: "Visa", OnBudget: true, Balance: -50000},
+	}
+	events := []forecastEvent{
+		{Date: date(t, "2022-01-02"), AccountID: "checking", Amount: -150000},
+		{Date: date(t, "2022-01-03"), AccountID: "visa", Amount: 50000},
+		{Date: date(t, "2022-01-03"), AccountID: "unknown", Amount: 50000},
+	}
+
+	forecast := forecastBalances(accounts, events, date(t, "2022-01-01"), 3)
+	assertInt(t, "len(forecast)", len(forecast), 4)
+	if got, want := forecast[1].Balances, []int{-50000, -50000}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("forecast[1].Balances = %v, want %v", got, want)
+	}
+	assertInt(t, "forecast[3].Total", forecast[3].Total, -50000)
+
+	warnings := negativeBalanceWarnings(accounts, forecast)
+	want := []string{"Checking is projected to go negative on 2022-01-02 (-50.00)"}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Fatalf("negativeBalanceWarnings = %v, want %v", warnings, want)
+	}
+}
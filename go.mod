@@ -2,4 +2,7 @@ module github.com/JanAhrens/ynab-sqlite
 
 go 1.19
 
-require github.com/mattn/go-sqlite3 v1.14.16
+require (
+	github.com/lib/pq v1.9.0
+	github.com/mattn/go-sqlite3 v1.14.16
+)
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+)
+
+// the API rejects longer values
+const (
+	maxPayeeNameLength = 50
+	maxMemoLength      = 200
+)
+
+func importCommand(sqlite sqliteService, store storage, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	account := flags.String("account", "", "name or id of the account the statement belongs to")
+	cleared := flags.String("cleared", "cleared", "cleared status of the imported transactions")
+	approved := flags.Bool("approved", false, "mark imported transactions as approved")
+	dryRun := flags.Bool("dry-run", false, "print the transactions instead of creating them")
+	statementMapping := csvMappingFlags(flags)
+	flags.Parse(args)
+
+	if *account == "" || flags.NArg() == 0 {
+		return fmt.Errorf("usage: import -account NAME [flags] FILE...")
+	}
+	mapping := statementMapping()
+
+	var accountID string
+	err := sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		accountID, err = findAccount(ctx, tx, *account)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var lines []statementLine
+	for _, path := range flags.Args() {
+		read, err := readStatementFile(path, mapping)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %s", path, err)
+		}
+		lines = append(lines, read...)
+	}
+
+	transactions := buildSaveTransactions(accountID, lines, *cleared, *approved)
+	if *dryRun {
+		for _, t := range transactions {
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", t.Date, formatAmount(t.Amount), *t.ImportID, stringValue(t.PayeeName), stringValue(t.Memo))
+		}
+		return nil
+	}
+	if len(transactions) == 0 {
+		log.Print("nothing to import")
+		return nil
+	}
+
+	ynab := ynabFromEnv()
+	saved, err := ynab.CreateTransactions(context.Background(), transactions)
+	if err != nil {
+		return fmt.Errorf("could not create transactions: %s", err)
+	}
+	log.Printf("created %d transactions, skipped %d duplicates",
+		len(saved.Data.TransactionIDs), len(saved.Data.DuplicateImportIDs))
+
+	return syncBudget(ynab, store)
+}
+
+// findAccount resolves an account name or id to the id of an open account.
+func findAccount(ctx context.Context, tx *sql.Tx, nameOrID string) (string, error) {
+	res, err := tx.QueryContext(ctx,
+		"SELECT id FROM account WHERE (id = ? OR name = ?) AND deleted <> 1 AND closed <> 1",
+		nameOrID, nameOrID)
+	if err != nil {
+		return "", err
+	}
+	defer res.Close()
+
+	var ids []string
+	for res.Next() {
+		var id string
+		if err := res.Scan(&id); err != nil {
+			return "", err
+		}
+		ids = append(ids, id)
+	}
+	if err := res.Err(); err != nil {
+		return "", err
+	}
+
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("account %q not found, run a sync first", nameOrID)
+	case 1:
+		return ids[0], nil
+	default:
+		return "", fmt.Errorf("account name %q is ambiguous, use the account id", nameOrID)
+	}
+}
+
+// buildSaveTransactions converts statement lines into API transactions. Every
+// transaction gets an import_id in the format YNAB uses for its own file
+// imports (YNAB:amount:date:occurrence), which makes importing the same
+// statement twice a no-op.
+func buildSaveTransactions(accountID string, lines []statementLine, cleared string, approved bool) []SaveTransaction {
+	occurrences := make(map[string]int)
+	var transactions []SaveTransaction
+	for _, line := range lines {
+		key := fmt.Sprintf("%d:%s", line.Amount, line.Date)
+		occurrences[key]++
+		importID := fmt.Sprintf("YNAB:%s:%d", key, occurrences[key])
+
+		transaction := SaveTransaction{
+			AccountID: accountID,
+			Date:      line.Date,
+			Amount:    line.Amount,
+			Cleared:   cleared,
+			Approved:  approved,
+			ImportID:  &importID,
+		}
+		if payee := truncate(line.Payee, maxPayeeNameLength); payee != "" {
+			transaction.PayeeName = &payee
+		}
+		if memo := truncate(line.Memo, maxMemoLength); memo != "" {
+			transaction.Memo = &memo
+		}
+		transactions = append(transactions, transaction)
+	}
+	return transactions
+}
+
+func truncate(value string, length int) string {
+	runes := []rune(value)
+	if len(runes) <= length {
+		return value
+	}
+	return string(runes[:length])
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// formatAmount formats milliunits as a decimal number, e.g. -1234500 as -1234.50
+func formatAmount(milliunits int) string {
+	sign := ""
+	if milliunits < 0 {
+		sign = "-"
+	}
+	milliunits = abs(milliunits)
+	return fmt.Sprintf("%s%d.%02d", sign, milliunits/1000, milliunits%1000/10)
+}
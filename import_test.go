@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFindAccount(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	var accounts Accounts
+	loadFixture("./fixtures/accounts.json", &accounts, t)
+	if err := updateAccounts(ctx, accounts, tx); err != nil {
+		t.Fatalf("updateAccounts err = %s, want nil", err)
+	}
+
+	id, err := findAccount(ctx, tx, "Checker")
+	if err != nil {
+		t.Fatalf("findAccount err = %s, want nil", err)
+	}
+	if want := "9a329f5e-1eca-40c6-8ba1-a19b0d8cadd1"; id != want {
+		t.Fatalf("findAccount = %q, want %q", id, want)
+	}
+
+	if _, err := findAccount(ctx, tx, "Unknown"); err == nil {
+		t.Fatal("findAccount err = nil, want error")
+	}
+}
+
+func TestBuildSaveTransactions(t *testing.T) {
+	lines := []statementLine{
+		{Date: "2021-11-24", Amount: -23000, Payee: "Hugo"},
+		{Date: "2021-11-24", Amount: -23000, Payee: "Hugo", Memo: "second"},
+		{Date: "2021-11-25", Amount: -23000},
+	}
+	transactions := buildSaveTransactions("account-id", lines, "cleared", false)
+	if got, want := len(transactions), 3; got != want {
+		t.Fatalf("len(transactions) = %d, want %d", got, want)
+	}
+	assertValue(t, "ImportID", *transactions[0].ImportID, "YNAB:-23000:2021-11-24:1")
+	assertValue(t, "ImportID", *transactions[1].ImportID, "YNAB:-23000:2021-11-24:2")
+	assertValue(t, "ImportID", *transactions[2].ImportID, "YNAB:-23000:2021-11-25:1")
+	assertValue(t, "AccountID", transactions[0].AccountID, "account-id")
+	assertValue(t, "PayeeName", *transactions[0].PayeeName, "Hugo")
+	assertNil(t, "Memo", transactions[0].Memo)
+	assertNil(t, "PayeeName", transactions[2].PayeeName)
+}
+
+func TestFormatAmount(t *testing.T) {
+	assertValue(t, "formatAmount", formatAmount(-1234500), "-1234.50")
+	assertValue(t, "formatAmount", formatAmount(990), "0.99")
+	assertValue(t, "formatAmount", formatAmount(0), "0.00")
+}
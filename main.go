@@ -2,68 +2,164 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Responses struct {
-	categories    Categories
-	months        Months
-	accounts      Accounts
-	transactions  Transactions
-	payees        Payees
-	categoryMonth []CategoryMonth
+	categories            Categories
+	months                Months
+	accounts              Accounts
+	transactions          Transactions
+	payees                Payees
+	categoryMonth         []CategoryMonth
+	scheduledTransactions ScheduledTransactions
 }
 
-func updateDatabase(ctx context.Context, tx *sql.Tx, responses Responses) error {
-	if err := updateCategories(ctx, responses.categories, tx); err != nil {
+func updateDatabase(ctx context.Context, tx storageTx, responses Responses) error {
+	if err := tx.UpdateCategories(ctx, responses.categories); err != nil {
 		return fmt.Errorf("couldn't update categories: %s", err)
 	}
 
-	if err := updateMonthServerKnowledge(ctx, responses.months, tx); err != nil {
+	if err := tx.UpdateMonthServerKnowledge(ctx, responses.months); err != nil {
 		return fmt.Errorf("could not update month server knowledge: %s", err)
 	}
 
-	if err := updateAccounts(ctx, responses.accounts, tx); err != nil {
+	if err := tx.UpdateAccounts(ctx, responses.accounts); err != nil {
 		log.Panicf("could not update accounts: %s", err)
 	}
 
-	if err := updateTransactions(ctx, responses.transactions, tx); err != nil {
+	if err := tx.UpdateTransactions(ctx, responses.transactions); err != nil {
 		log.Panicf("could not update transactions: %s", err)
 	}
 
+	if err := tx.UpdateScheduledTransactions(ctx, responses.scheduledTransactions); err != nil {
+		log.Panicf("could not update scheduled transactions: %s", err)
+	}
+
 	for _, month := range responses.months.Data.Months {
-		if err := updateMonth(ctx, month, tx); err != nil {
+		if err := tx.UpdateMonth(ctx, month); err != nil {
 			log.Panicf("could not update months: %s", err)
 		}
 	}
 
 	for _, categoryMonth := range responses.categoryMonth {
-		if err := updateCategoryMonth(ctx, categoryMonth, tx); err != nil {
+		if err := tx.UpdateCategoryMonth(ctx, categoryMonth); err != nil {
 			log.Panicf("could not update category month %s", err)
 		}
 	}
 
-	return updatePayees(ctx, responses.payees, tx)
+	return tx.UpdatePayees(ctx, responses.payees)
 }
 
-func main() {
+// rowCounts returns the number of rows the responses upsert per table.
+func (responses Responses) rowCounts() map[string]int {
+	counts := map[string]int{
+		"account":               len(responses.accounts.Data.Accounts),
+		"month":                 len(responses.months.Data.Months),
+		"payee":                 len(responses.payees.Data.Payees),
+		"scheduled_transaction": len(responses.scheduledTransactions.Data.ScheduledTransactions),
+		"transaction":           len(responses.transactions.Data.Transactions),
+	}
+	for _, group := range responses.categories.Data.CategoryGroups {
+		counts["category_group"]++
+		counts["category"] += len(group.Categories)
+	}
+	for _, t := range responses.transactions.Data.Transactions {
+		counts["subtransaction"] += len(t.Subtransactions)
+	}
+	for _, categoryMonth := range responses.categoryMonth {
+		counts["category_month"] += len(categoryMonth.Data.Month.Categories)
+	}
+	return counts
+}
+
+// syncResult is what a sync changed
+type syncResult struct {
+	Rows   map[string]int // upserted rows per table
+	Events []changeEvent
+}
+
+// syncBudget downloads everything that changed since the last run and stores
+// it.
+func syncBudget(ynab YNAB, store storage) error {
+	_, err := syncBudgetContext(context.Background(), ynab, store)
+	return err
+}
+
+// syncBudgetContext is syncBudget with a context. Nothing is stored if ctx is
+// cancelled before the sync is finished. The change events are sent to the
+// webhooks in the background once they are stored.
+func syncBudgetContext(ctx context.Context, ynab YNAB, store storage) (syncResult, error) {
+	var result syncResult
+	err := store.Sync(ctx, func(ctx context.Context, tx storageTx) error {
+		serverKnowledge, err := tx.LoadServerKnowledge(ctx)
+		if err != nil {
+			return err
+		}
+
+		responses := Responses{
+			categories:            ynab.LoadCategories(ctx, serverKnowledge["categories"]),
+			months:                ynab.LoadMonths(ctx, serverKnowledge["months"]),
+			accounts:              ynab.LoadAccounts(ctx, serverKnowledge["accounts"]),
+			transactions:          ynab.LoadTransactions(ctx, serverKnowledge["transactions"]),
+			payees:                ynab.LoadPayees(ctx, serverKnowledge["payees"]),
+			scheduledTransactions: ynab.LoadScheduledTransactions(ctx, serverKnowledge["scheduled_transactions"]),
+			categoryMonth:         nil, // wait until months are loaded and only load required monthly budgets
+		}
+
+		for _, month := range responses.months.Data.Months {
+			responses.categoryMonth = append(responses.categoryMonth, ynab.LoadCategoryMonths(ctx, month.Month))
+		}
+
+		result.Rows = responses.rowCounts()
+		// the first sync brings in the whole history, which isn't a change
+		if serverKnowledge["transactions"] > 0 {
+			if result.Events, err = tx.ChangeEvents(ctx, responses); err != nil {
+				return fmt.Errorf("could not compute change events: %s", err)
+			}
+		}
+		run, err := tx.StartSyncRun(ctx)
+		if err != nil {
+			return fmt.Errorf("could not start sync run: %s", err)
+		}
+		if err := updateDatabase(ctx, tx, responses); err != nil {
+			return err
+		}
+		return tx.FinishSyncRun(ctx, run)
+	})
+	if err != nil {
+		return syncResult{}, fmt.Errorf("failure in database transaction: %s", err)
+	}
+
+	notifyWebhooks(webhooksFromEnv(), result.Events)
+	return result, nil
+}
+
+func ynabFromEnv() YNAB {
 	apiKey, ok := os.LookupEnv("YNAB_API_KEY")
 	if !ok {
 		log.Fatal("YNAB_API_KEY not set")
 	}
 
-	ynab := NewYNAB(
+	return NewYNAB(
 		"https://api.youneedabudget.com/v1",
 		apiKey,
 		"last-used",
 	)
+}
 
-	db, err := sql.Open("sqlite3", "database.db")
+func main() {
+	command, args := "sync", os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	db, err := openSqlite("database.db")
 	if err != nil {
 		log.Fatal("Database connection failed")
 	}
@@ -75,28 +171,64 @@ func main() {
 		log.Fatal("failed to create database tables")
 	}
 
-	err = sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
-		serverKnowledge, err := loadServerKnowledge(ctx, tx)
-		if err != nil {
-			return err
-		}
-
-		responses := Responses{
-			categories:    ynab.LoadCategories(serverKnowledge["categories"]),
-			months:        ynab.LoadMonths(serverKnowledge["months"]),
-			accounts:      ynab.LoadAccounts(serverKnowledge["accounts"]),
-			transactions:  ynab.LoadTransactions(serverKnowledge["transactions"]),
-			payees:        ynab.LoadPayees(serverKnowledge["payees"]),
-			categoryMonth: nil, // wait until months are loaded and only load required monthly budgets
-		}
-
-		for _, month := range responses.months.Data.Months {
-			responses.categoryMonth = append(responses.categoryMonth, ynab.LoadCategoryMonths(month.Month))
-		}
-
-		return updateDatabase(ctx, tx, responses)
-	})
+	// syncs are mirrored into PostgreSQL if POSTGRES_URL is set, including
+	// those of the commands that sync after changing the budget
+	store, err := storageFromEnv(sqlite)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	switch command {
+	case "sync":
+		err = syncBudget(ynabFromEnv(), store)
+	case "daemon":
+		err = daemonCommand(sqlite, store, args)
+	case "serve":
+		err = serveCommand(sqlite, args)
+	case "import":
+		err = importCommand(sqlite, store, args)
+	case "reconcile":
+		err = reconcileCommand(sqlite, args)
+	case "budget":
+		err = budgetCommand(sqlite, store, args)
+	case "tx":
+		err = txCommand(sqlite, store, args)
+	case "duplicates":
+		err = duplicatesCommand(sqlite, args)
+	case "check":
+		err = checkCommand(sqlite, args)
+	case "rules":
+		err = rulesCommand(sqlite, store, args)
+	case "suggest":
+		err = suggestCommand(sqlite, args)
+	case "recurring":
+		err = recurringCommand(sqlite, args)
+	case "forecast":
+		err = forecastCommand(sqlite, args)
+	case "anomalies":
+		err = anomaliesCommand(sqlite, args)
+	case "age-of-money":
+		err = ageOfMoneyCommand(sqlite, args)
+	case "payees":
+		err = payeesCommand(sqlite, store, args)
+	case "digest":
+		err = digestCommand(sqlite, args)
+	case "search":
+		err = searchCommand(sqlite, args)
+	case "report":
+		err = reportCommand(sqlite, args)
+	case "changes":
+		err = changesCommand(sqlite, args)
+	default:
+		err = fmt.Errorf("unknown command %q", command)
+	}
+	// the events of this run are lost once it exits, give the webhooks all
+	// their retries
+	if pending := waitForWebhooks(webhooksDeliveryTime(webhooksFromEnv()) + time.Second); pending > 0 {
+		log.Printf("gave up waiting for %d webhook deliveries, their events are dropped", pending)
+	}
 	if err != nil {
-		log.Fatal("failure in database transaction")
+		log.Fatal(err)
 	}
 }
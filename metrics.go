@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syncMetrics collects the results of the syncs of the daemon
+type syncMetrics struct {
+	mu           sync.Mutex
+	syncs        map[string]int // by result, success or failure
+	lastDuration time.Duration
+	lastSuccess  time.Time
+	rows         map[string]int // upserted rows per table
+}
+
+func newSyncMetrics() *syncMetrics {
+	return &syncMetrics{syncs: make(map[string]int), rows: make(map[string]int)}
+}
+
+func (m *syncMetrics) record(duration time.Duration, rows map[string]int, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastDuration = duration
+	if err != nil {
+		m.syncs["failure"]++
+		return
+	}
+	m.syncs["success"]++
+	m.lastSuccess = time.Now()
+	for table, count := range rows {
+		m.rows[table] += count
+	}
+}
+
+// metricWriter writes the Prometheus text exposition format
+type metricWriter struct {
+	w io.Writer
+}
+
+func (m metricWriter) metric(name string, typ string, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a value, labels are pairs of label name and value.
+func (m metricWriter) sample(name string, value float64, labels ...string) {
+	var formatted []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		formatted = append(formatted, fmt.Sprintf(`%s="%s"`, labels[i], escaped))
+	}
+	if len(formatted) > 0 {
+		name += "{" + strings.Join(formatted, ",") + "}"
+	}
+	fmt.Fprintf(m.w, "%s %s\n", name, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+func (m *syncMetrics) write(w metricWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.metric("ynab_syncs_total", "counter", "Number of syncs by result.")
+	for _, result := range []string{"success", "failure"} {
+		w.sample("ynab_syncs_total", float64(m.syncs[result]), "result", result)
+	}
+	w.metric("ynab_sync_duration_seconds", "gauge", "Duration of the last sync.")
+	w.sample("ynab_sync_duration_seconds", m.lastDuration.Seconds())
+	w.metric("ynab_sync_last_success_timestamp_seconds", "gauge", "Time of the last successful sync.")
+	var lastSuccess float64
+	if !m.lastSuccess.IsZero() {
+		lastSuccess = float64(m.lastSuccess.Unix())
+	}
+	w.sample("ynab_sync_last_success_timestamp_seconds", lastSuccess)
+
+	w.metric("ynab_sync_rows_upserted_total", "counter", "Number of rows upserted by syncs per table.")
+	var tables []string
+	for table := range m.rows {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		w.sample("ynab_sync_rows_upserted_total", float64(m.rows[table]), "table", table)
+	}
+}
+
+func writeAPIMetrics(w metricWriter, ynab YNAB) {
+	counts := ynab.RequestCounts()
+	var requests []apiRequest
+	for request := range counts {
+		requests = append(requests, request)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		if a.Endpoint != b.Endpoint {
+			return a.Endpoint < b.Endpoint
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Code < b.Code
+	})
+	w.metric("ynab_api_requests_total", "counter", "Number of API requests by method, endpoint and HTTP status code.")
+	for _, request := range requests {
+		w.sample("ynab_api_requests_total", float64(counts[request]),
+			"method", request.Method, "endpoint", request.Endpoint, "code", strconv.Itoa(request.Code))
+	}
+	if remaining, ok := ynab.RemainingRequests(); ok {
+		w.metric("ynab_api_rate_limit_remaining", "gauge", "Remaining API requests in the current hour.")
+		w.sample("ynab_api_rate_limit_remaining", float64(remaining))
+	}
+}
+
+// writeBudgetMetrics writes gauges of the synced budget. Category balances
+// and to be budgeted are those of month, YYYY-MM-01.
+func writeBudgetMetrics(ctx context.Context, tx *sql.Tx, w metricWriter, month string) error {
+	gauge := func(name string, help string, query string, labels []string, args ...interface{}) error {
+		res, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer res.Close()
+
+		w.metric(name, "gauge", help)
+		for res.Next() {
+			values := make([]string, len(labels))
+			var value int
+			dest := []interface{}{&value}
+			for i := range values {
+				dest = append(dest, &values[i])
+			}
+			if err := res.Scan(dest...); err != nil {
+				return err
+			}
+			var pairs []string
+			for i, label := range labels {
+				pairs = append(pairs, label, values[i])
+			}
+			w.sample(name, float64(value)/1000, pairs...)
+		}
+		return res.Err()
+	}
+
+	// names don't have to be unique, the ids keep the label sets apart
+	err := gauge("ynab_account_balance", "Balance of open accounts (cleared and uncleared).", `
+		SELECT IFNULL(cleared_balance, 0) + IFNULL(uncleared_balane, 0), name, id, IFNULL(type, ''),
+			CASE WHEN on_budget = 1 THEN 'true' ELSE 'false' END
+		FROM account
+		WHERE deleted <> 1 AND closed <> 1
+		ORDER BY name, id`,
+		[]string{"account", "account_id", "type", "on_budget"})
+	if err != nil {
+		return err
+	}
+	err = gauge("ynab_to_be_budgeted", "Money that is not assigned to a category in the current month.", `
+		SELECT IFNULL(to_be_budgeted, 0), substr(id, 1, 7)
+		FROM month
+		WHERE id = ? AND deleted <> 1`,
+		[]string{"month"}, month)
+	if err != nil {
+		return err
+	}
+	return gauge("ynab_category_balance", "Balance of categories in the current month.", `
+		SELECT IFNULL(cm.balance, 0), g.name, c.name, c.id
+		FROM category_month cm
+		JOIN category c ON c.id = cm.category_id
+		JOIN category_group g ON g.id = c.category_group_id
+		WHERE cm.month_id = ? AND c.deleted <> 1 AND c.hidden <> 1 AND g.deleted <> 1 AND g.hidden <> 1
+		ORDER BY g.name, c.name, c.id`,
+		[]string{"group", "category", "category_id"}, month)
+}
+
+// metricsHandler serves the metrics. sync is nil if no syncs run in this
+// process.
+func metricsHandler(sqlite sqliteService, ynab YNAB, sync *syncMetrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buffer bytes.Buffer
+		writer := metricWriter{&buffer}
+		if sync != nil {
+			sync.write(writer)
+		}
+		writeAPIMetrics(writer, ynab)
+		month := time.Now().Format("2006-01") + "-01"
+		err := sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+			return writeBudgetMetrics(ctx, tx, writer, month)
+		})
+		if err != nil {
+			log.Printf("could not collect metrics: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buffer.Bytes())
+	})
+}
+
+// newMetricsServer returns a HTTP server with the /metrics endpoint.
+func newMetricsServer(address string, handler http.Handler) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	return &http.Server{Addr: address, Handler: mux}
+}
+
+// serveCommand only serves the budget metrics of the database, another
+// process keeps it up to date.
+func serveCommand(sqlite sqliteService, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := flags.String("listen", ":9090", "address of the metrics endpoint")
+	flags.Parse(args)
+
+	server := newMetricsServer(*listen, metricsHandler(sqlite, YNAB{}, nil))
+	log.Printf("serving metrics on %s/metrics", *listen)
+	return server.ListenAndServe()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEndpointName(t *testing.T) {
+	tests := map[string]string{
+		"https://api.youneedabudget.com/v1/budgets/last-used/categories?last_knowledge_of_server=0": "categories",
+		"http://localhost/budgets/last-used/months/2022-12-01/categories/cb19a998-9264":             "months/categories",
+		"http://localhost/budgets/last-used/scheduled_transactions":                                 "scheduled_transactions",
+		"http://localhost/budgets/last-used":                                                        "budgets",
+	}
+	for url, want := range tests {
+		if got := endpointName(url); got != want {
+			t.Fatalf("endpointName(%q) = %q, want %q", url, got, want)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	db, sqlite := prepareFileDB(t)
+	defer db.Close()
+
+	month := time.Now().Format("2006-01") + "-01"
+	for _, query := range []string{
+		`INSERT INTO account (id, name, type, on_budget, closed, deleted, cleared_balance, uncleared_balane)
+			VALUES ('1', 'Checker', 'checking', 1, 0, 0, 1000000, -20500), ('2', 'Old', 'savings', 1, 1, 0, 0, 0),
+			('3', 'Checker', 'savings', 0, 0, 0, 5000, 0)`,
+		`INSERT INTO month (id, to_be_budgeted, deleted) VALUES ('` + month + `', 12340, 0), ('2000-01-01', 1, 0)`,
+		`INSERT INTO category_group (id, name, hidden, deleted) VALUES ('g', 'Bills "monthly"', 0, 0)`,
+		`INSERT INTO category (id, category_group_id, name, hidden, deleted) VALUES ('c', 'g', 'Rent', 0, 0)`,
+		`INSERT INTO category_month (month_id, category_id, balance) VALUES ('` + month + `', 'c', 800000)`,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("failed to execute %q: %s", query, err)
+		}
+	}
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Rate-Limit", "10/200")
+		w.Write([]byte("{}"))
+	}))
+	defer api.Close()
+	ynab := NewYNAB(api.URL, "token", "last-used")
+	ynab.request(context.Background(), api.URL+"/budgets/last-used/payees")
+
+	metrics := newSyncMetrics()
+	metrics.record(1500*time.Millisecond, map[string]int{"transaction": 3}, nil)
+
+	// a sync commits while the metrics are scraped
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get connection: %s", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), "BEGIN EXCLUSIVE"); err != nil {
+		t.Fatalf("failed to lock database: %s", err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn.ExecContext(context.Background(), "COMMIT")
+	}()
+
+	ts := httptest.NewServer(metricsHandler(sqlite, ynab, metrics))
+	defer ts.Close()
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("GET /metrics err = %s, want nil", err)
+	}
+	defer res.Body.Close()
+	content, _ := io.ReadAll(res.Body)
+	body := string(content)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics status = %d, want 200:\n%s", res.StatusCode, body)
+	}
+
+	for _, want := range []string{
+		`ynab_syncs_total{result="success"} 1`,
+		`ynab_sync_duration_seconds 1.5`,
+		`ynab_sync_rows_upserted_total{table="transaction"} 3`,
+		`ynab_api_requests_total{method="GET",endpoint="payees",code="200"} 1`,
+		`ynab_api_rate_limit_remaining 190`,
+		`ynab_account_balance{account="Checker",account_id="1",type="checking",on_budget="true"} 979.5`,
+		`ynab_account_balance{account="Checker",account_id="3",type="savings",on_budget="false"} 5`,
+		`ynab_to_be_budgeted{month="` + month[:7] + `"} 12.34`,
+		`ynab_category_balance{group="Bills \"monthly\"",category="Rent",category_id="c"} 800`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Fatalf("metrics don't contain %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `account="Old"`) {
+		t.Fatalf("metrics contain the closed account:\n%s", body)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"time"
+)
+
+// liabilityTypes are the account types YNAB treats as debts. Every other
+// account type is an asset.
+var liabilityTypes = map[string]bool{
+	"creditCard":     true,
+	"lineOfCredit":   true,
+	"otherLiability": true,
+	"mortgage":       true,
+	"autoLoan":       true,
+	"studentLoan":    true,
+	"personalLoan":   true,
+	"medicalDebt":    true,
+	"otherDebt":      true,
+}
+
+type networthAccount struct {
+	Liability bool
+	OnBudget  bool
+	Balance   int
+}
+
+// networthReport shows the balances at the end of every month, split into
+// assets and liabilities of on-budget and tracking accounts. Closed accounts
+// are included, their balance is zero after they were closed.
+func networthReport(ctx context.Context, tx *sql.Tx, args []string) (report, string, error) {
+	flags := flag.NewFlagSet("report networth", flag.ExitOnError)
+	monthRange := monthRangeFlags(flags)
+	format := flags.String("format", "table", "output format, table, csv, json or markdown")
+	flags.Parse(args)
+
+	from, to, err := monthRange()
+	if err != nil {
+		return report{}, "", err
+	}
+
+	res, err := tx.QueryContext(ctx, `
+		SELECT t.account_id, IFNULL(a.type, ''), a.on_budget, substr(t.date, 1, 7) || '-01', SUM(t.amount)
+		FROM "transaction" t
+		JOIN account a ON a.id = t.account_id
+		WHERE t.deleted <> 1 AND a.deleted <> 1
+		GROUP BY t.account_id, substr(t.date, 1, 7)
+		ORDER BY substr(t.date, 1, 7)`)
+	if err != nil {
+		return report{}, "", err
+	}
+	defer res.Close()
+
+	type monthlyChange struct {
+		accountID string
+		amount    int
+	}
+	accounts := make(map[string]*networthAccount)
+	changes := make(map[string][]monthlyChange)
+	var first, last string
+	for res.Next() {
+		var accountID, accountType, month string
+		var onBudget bool
+		var sum int
+		if err := res.Scan(&accountID, &accountType, &onBudget, &month, &sum); err != nil {
+			return report{}, "", err
+		}
+		if accounts[accountID] == nil {
+			accounts[accountID] = &networthAccount{Liability: liabilityTypes[accountType], OnBudget: onBudget}
+		}
+		changes[month] = append(changes[month], monthlyChange{accountID, sum})
+		if first == "" {
+			first = month
+		}
+		last = month
+	}
+	if err := res.Err(); err != nil {
+		return report{}, "", err
+	}
+	if to != "" {
+		last = to
+	}
+
+	result := report{Columns: []string{"month", "on_budget_assets", "on_budget_liabilities",
+		"tracking_assets", "tracking_liabilities", "assets", "liabilities", "net_worth"}}
+	if first == "" {
+		return result, *format, nil
+	}
+
+	month, _ := time.Parse("2006-01-02", first)
+	for ; month.Format("2006-01-02") <= last; month = month.AddDate(0, 1, 0) {
+		key := month.Format("2006-01-02")
+		for _, change := range changes[key] {
+			accounts[change.accountID].Balance += change.amount
+		}
+		if key < from {
+			continue
+		}
+
+		var onBudgetAssets, onBudgetLiabilities, trackingAssets, trackingLiabilities int
+		for _, account := range accounts {
+			switch {
+			case account.OnBudget && account.Liability:
+				onBudgetLiabilities += account.Balance
+			case account.OnBudget:
+				onBudgetAssets += account.Balance
+			case account.Liability:
+				trackingLiabilities += account.Balance
+			default:
+				trackingAssets += account.Balance
+			}
+		}
+		assets := onBudgetAssets + trackingAssets
+		liabilities := onBudgetLiabilities + trackingLiabilities
+		result.add(key[:7], amount(onBudgetAssets), amount(onBudgetLiabilities),
+			amount(trackingAssets), amount(trackingLiabilities),
+			amount(assets), amount(liabilities), amount(assets+liabilities))
+	}
+
+	return result, *format, nil
+}
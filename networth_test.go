@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNetworthReport(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	var accounts Accounts
+	loadFixture("./fixtures/accounts.json", &accounts, t)
+	if err := updateAccounts(ctx, accounts, tx); err != nil {
+		t.Fatalf("updateAccounts err = %s, want nil", err)
+	}
+	mustExec(ctx, tx, t, `INSERT INTO account (id, name, type, on_budget, closed, deleted)
+		VALUES ('mortgage', 'House', 'mortgage', 0, 0, 0), ('old', 'Old savings', 'savings', 1, 1, 0)`)
+	for _, row := range []struct {
+		id, date, account string
+		amount            int
+	}{
+		{"1", "2022-01-01", "9a329f5e-1eca-40c6-8ba1-a19b0d8cadd1", 1000000},
+		{"2", "2022-01-15", "95d0b9ce-2c8d-436c-b239-590aa963e547", -200000},
+		{"3", "2022-01-01", "mortgage", -5000000},
+		{"4", "2022-01-01", "old", 300000},
+		// the savings account was emptied and closed
+		{"5", "2022-03-10", "old", -300000},
+		{"6", "2022-03-10", "9a329f5e-1eca-40c6-8ba1-a19b0d8cadd1", 300000},
+	} {
+		mustExec(ctx, tx, t, `INSERT INTO "transaction" (id, date, amount, account_id, deleted) VALUES (?, ?, ?, ?, 0)`,
+			row.id, row.date, row.amount, row.account)
+	}
+
+	r, _, err := networthReport(ctx, tx, []string{"-from", "2022-02"})
+	if err != nil {
+		t.Fatalf("networthReport err = %s, want nil", err)
+	}
+	want := [][]interface{}{
+		{"2022-02", amount(1300000), amount(-200000), amount(0), amount(-5000000), amount(1300000), amount(-5200000), amount(-3900000)},
+		{"2022-03", amount(1300000), amount(-200000), amount(0), amount(-5000000), amount(1300000), amount(-5200000), amount(-3900000)},
+	}
+	if !reflect.DeepEqual(r.Rows, want) {
+		t.Fatalf("networthReport = %v, want %v", r.Rows, want)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// changeEvent is a change brought in by a sync that webhooks are notified of
+type changeEvent struct {
+	Type          string `json:"type"` // transaction.created, transaction.updated, transaction.deleted, category.overspent or account.import_error
+	ID            string `json:"id"`
+	Date          string `json:"date,omitempty"`
+	Month         string `json:"month,omitempty"`
+	Amount        *int   `json:"amount,omitempty"`  // milliunits
+	Balance       *int   `json:"balance,omitempty"` // milliunits
+	AccountName   string `json:"account_name,omitempty"`
+	PayeeName     string `json:"payee_name,omitempty"`
+	CategoryName  string `json:"category_name,omitempty"`
+	CategoryGroup string `json:"category_group,omitempty"`
+}
+
+// rowQuery runs a query that returns a single row, like sql.Tx.QueryRowContext
+type rowQuery func(ctx context.Context, query string, args ...interface{}) *sql.Row
+
+// changeEvents compares the responses of a sync with the database before
+// they are stored. Categories and accounts only cause an event when they
+// become overspent or fail to import, not on every sync they stay that way.
+// The queries work in SQLite and, with the placeholders rebound, PostgreSQL.
+func changeEvents(ctx context.Context, queryRow rowQuery, responses Responses) ([]changeEvent, error) {
+	var events []changeEvent
+
+	for _, t := range responses.transactions.Data.Transactions {
+		var deleted bool
+		err := queryRow(ctx, `SELECT deleted FROM "transaction" WHERE id = ?`, t.ID).Scan(&deleted)
+		exists := err == nil && !deleted
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		var eventType string
+		switch {
+		case t.Deleted && exists:
+			eventType = "transaction.deleted"
+		case t.Deleted:
+			continue
+		case exists:
+			eventType = "transaction.updated"
+		default:
+			eventType = "transaction.created"
+		}
+		amount := t.Amount
+		events = append(events, changeEvent{
+			Type: eventType, ID: t.ID, Date: t.Date, Amount: &amount,
+			AccountName: t.AccountName, PayeeName: t.PayeeName, CategoryName: t.CategoryName,
+		})
+	}
+
+	groupNames := make(map[string]string)
+	for _, group := range responses.categories.Data.CategoryGroups {
+		groupNames[group.ID] = group.Name
+	}
+	for _, categoryMonth := range responses.categoryMonth {
+		month := categoryMonth.Data.Month.Month.Month
+		for _, category := range categoryMonth.Data.Month.Categories {
+			if category.Balance >= 0 || category.Deleted {
+				continue
+			}
+			var previous int
+			err := queryRow(ctx, `SELECT COALESCE(balance, 0) FROM category_month WHERE month_id = ? AND category_id = ?`,
+				month, category.ID).Scan(&previous)
+			if err != nil && err != sql.ErrNoRows {
+				return nil, err
+			}
+			if err == nil && previous < 0 {
+				continue
+			}
+			group, ok := groupNames[category.CategoryGroupID]
+			if !ok {
+				// unchanged groups are not part of the response
+				queryRow(ctx, "SELECT name FROM category_group WHERE id = ?", category.CategoryGroupID).Scan(&group)
+			}
+			balance := category.Balance
+			events = append(events, changeEvent{
+				Type: "category.overspent", ID: category.ID, Month: month, Balance: &balance,
+				CategoryName: category.Name, CategoryGroup: group,
+			})
+		}
+	}
+
+	for _, account := range responses.accounts.Data.Accounts {
+		if !account.DirectImportInError || account.Deleted {
+			continue
+		}
+		var inError bool
+		err := queryRow(ctx, `SELECT COALESCE(direct_import_in_error, false) FROM account WHERE id = ?`, account.ID).Scan(&inError)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if !inError {
+			events = append(events, changeEvent{Type: "account.import_error", ID: account.ID, AccountName: account.Name})
+		}
+	}
+	return events, nil
+}
+
+// webhook receives the change events of every sync as JSON. The body is
+// signed with HMAC-SHA256 in the X-Signature-256 header if a secret is set.
+type webhook struct {
+	URL      string
+	Secret   string
+	Attempts int
+	Backoff  time.Duration // before the first retry, doubled for every further retry
+	client   *http.Client
+}
+
+// webhooksFromEnv reads the comma separated WEBHOOK_URLS and the optional
+// WEBHOOK_SECRET.
+func webhooksFromEnv() []webhook {
+	var webhooks []webhook
+	for _, url := range strings.Split(os.Getenv("WEBHOOK_URLS"), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			webhooks = append(webhooks, webhook{
+				URL:      url,
+				Secret:   os.Getenv("WEBHOOK_SECRET"),
+				Attempts: 4,
+				Backoff:  time.Second,
+				client:   &http.Client{Timeout: 10 * time.Second},
+			})
+		}
+	}
+	return webhooks
+}
+
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliveryTime is the longest time send can take: every attempt runs into the
+// timeout of the client and all backoffs are waited for.
+func (hook webhook) deliveryTime() time.Duration {
+	var total time.Duration
+	if hook.client != nil {
+		total = time.Duration(hook.Attempts) * hook.client.Timeout
+	}
+	backoff := hook.Backoff
+	for attempt := 1; attempt < hook.Attempts; attempt++ {
+		total += backoff
+		backoff *= 2
+	}
+	return total
+}
+
+// webhooksDeliveryTime is the longest delivery time of webhooks, they are
+// notified in parallel.
+func webhooksDeliveryTime(webhooks []webhook) time.Duration {
+	var longest time.Duration
+	for _, hook := range webhooks {
+		if d := hook.deliveryTime(); d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+// send posts the events. Network errors, rate limiting and server errors are
+// retried, other client errors are not.
+func (hook webhook) send(events []changeEvent) error {
+	payload, err := json.Marshal(struct {
+		SentAt string        `json:"sent_at"`
+		Events []changeEvent `json:"events"`
+	}{time.Now().UTC().Format(time.RFC3339), events})
+	if err != nil {
+		return err
+	}
+
+	client := hook.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	backoff := hook.Backoff
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if hook.Secret != "" {
+			req.Header.Set("X-Signature-256", signPayload(hook.Secret, payload))
+		}
+
+		res, err := client.Do(req)
+		retry := true
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode >= 200 && res.StatusCode <= 299 {
+				return nil
+			}
+			err = fmt.Errorf("failed request with status code %d", res.StatusCode)
+			retry = res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+		}
+		if !retry || attempt >= hook.Attempts {
+			return err
+		}
+		log.Printf("webhook %s failed (attempt %d): %s", hook.URL, attempt, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// webhookDeliveries are the webhook requests that are still running,
+// pendingDeliveries counts them
+var webhookDeliveries sync.WaitGroup
+var pendingDeliveries int32
+
+// notifyWebhooks sends the events to all webhooks in the background, so slow
+// webhooks don't hold up the sync. Failures are logged, they don't fail the
+// sync.
+func notifyWebhooks(webhooks []webhook, events []changeEvent) {
+	if len(events) == 0 {
+		return
+	}
+	for _, hook := range webhooks {
+		webhookDeliveries.Add(1)
+		atomic.AddInt32(&pendingDeliveries, 1)
+		go func(hook webhook) {
+			defer webhookDeliveries.Done()
+			defer atomic.AddInt32(&pendingDeliveries, -1)
+			if err := hook.send(events); err != nil {
+				log.Printf("could not notify webhook %s: %s", hook.URL, err)
+			}
+		}(hook)
+	}
+}
+
+// waitForWebhooks waits until the events are sent to all webhooks, at most
+// timeout. It returns the number of deliveries that are still running.
+func waitForWebhooks(timeout time.Duration) int {
+	done := make(chan struct{})
+	go func() {
+		webhookDeliveries.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return 0
+	case <-time.After(timeout):
+		return int(atomic.LoadInt32(&pendingDeliveries))
+	}
+}
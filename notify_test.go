@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChangeEvents(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	var accounts Accounts
+	loadFixture("./fixtures/accounts.json", &accounts, t)
+	var categories Categories
+	loadFixture("./fixtures/categories.json", &categories, t)
+	var transactions Transactions
+	loadFixture("./fixtures/transactions.json", &transactions, t)
+	if err := updateAccounts(ctx, accounts, tx); err != nil {
+		t.Fatalf("updateAccounts err = %s, want nil", err)
+	}
+	if err := updateCategories(ctx, categories, tx); err != nil {
+		t.Fatalf("updateCategories err = %s, want nil", err)
+	}
+	if err := updateTransactions(ctx, transactions, tx); err != nil {
+		t.Fatalf("updateTransactions err = %s, want nil", err)
+	}
+	mustExec(ctx, tx, t, `INSERT INTO category_month (month_id, category_id, balance) VALUES
+		('2022-12-01', '7d3b19a3-a347-4a10-befc-b966f278aa3e', 1000),
+		('2022-12-01', 'cb19a998-9264-4255-a63c-349c586caeed', -1000)`)
+
+	var responses Responses
+	loadFixture("./fixtures/transactions.json", &responses.transactions, t)
+	responses.transactions.Data.Transactions[0].Deleted = true
+	responses.transactions.Data.Transactions[2].ID = "new"
+	responses.transactions.Data.Transactions = responses.transactions.Data.Transactions[:3]
+	loadFixture("./fixtures/accounts.json", &responses.accounts, t)
+	responses.accounts.Data.Accounts[1].DirectImportInError = true
+	var categoryMonth CategoryMonth
+	categoryMonth.Data.Month.Month.Month = "2022-12-01"
+	for _, c := range categories.Data.CategoryGroups[2].Categories {
+		if c.ID == "7d3b19a3-a347-4a10-befc-b966f278aa3e" || c.ID == "cb19a998-9264-4255-a63c-349c586caeed" {
+			c.Balance = -5000
+			categoryMonth.Data.Month.Categories = append(categoryMonth.Data.Month.Categories, c)
+		}
+	}
+	responses.categoryMonth = []CategoryMonth{categoryMonth}
+
+	events, err := changeEvents(ctx, tx.QueryRowContext, responses)
+	if err != nil {
+		t.Fatalf("changeEvents err = %s, want nil", err)
+	}
+	var got []string
+	for _, event := range events {
+		got = append(got, event.Type+" "+event.ID)
+	}
+	want := []string{
+		"transaction.deleted 295c1843-14dd-46ed-bed5-3d02c17a82db",
+		"transaction.updated d11bc464-2aa1-42e1-96e7-a1c468e78ae9",
+		"transaction.created new",
+		"category.overspent 7d3b19a3-a347-4a10-befc-b966f278aa3e",
+		"account.import_error 95d0b9ce-2c8d-436c-b239-590aa963e547",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("changeEvents = %q, want %q", got, want)
+	}
+	assertValue(t, "CategoryGroup", events[3].CategoryGroup, "Immediate Obligations")
+	assertInt(t, "Balance", *events[3].Balance, -5000)
+}
+
+func TestWebhookSend(t *testing.T) {
+	var requests int
+	var signature, body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		content, _ := io.ReadAll(r.Body)
+		signature, body = r.Header.Get("X-Signature-256"), string(content)
+	}))
+	defer ts.Close()
+
+	hook := webhook{URL: ts.URL, Secret: "secret", Attempts: 3}
+	if err := hook.send([]changeEvent{{Type: "transaction.created", ID: "1"}}); err != nil {
+		t.Fatalf("send err = %s, want nil", err)
+	}
+	assertInt(t, "requests", requests, 2)
+	assertValue(t, "X-Signature-256", signature, signPayload("secret", []byte(body)))
+
+	requests = 0
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+	hook.URL = rejecting.URL
+	if err := hook.send([]changeEvent{{Type: "transaction.created", ID: "1"}}); err == nil {
+		t.Fatal("send err = nil, want error")
+	}
+	assertInt(t, "requests", requests, 1)
+}
+
+func TestNotifyWebhooks(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+
+	start := time.Now()
+	notifyWebhooks([]webhook{{URL: ts.URL, Attempts: 1}}, []changeEvent{{Type: "transaction.created", ID: "1"}})
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("notifyWebhooks took %s, want it to return before the webhook answers", elapsed)
+	}
+	assertInt(t, "pending deliveries", waitForWebhooks(50*time.Millisecond), 1)
+	close(release)
+	assertInt(t, "pending deliveries", waitForWebhooks(5*time.Second), 0)
+}
+
+func TestWebhooksDeliveryTime(t *testing.T) {
+	hook := webhook{Attempts: 4, Backoff: time.Second, client: &http.Client{Timeout: 10 * time.Second}}
+	// four timeouts and the backoffs of 1, 2 and 4 seconds
+	assertValue(t, "deliveryTime", webhooksDeliveryTime([]webhook{{Attempts: 1}, hook}), 47*time.Second)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// payeeNoise are words that don't distinguish payees, like legal forms, top
+// level domains and the abbreviations banks add to card payments
+var payeeNoise = map[string]bool{
+	"com": true, "de": true, "co": true, "uk": true, "net": true, "org": true, "www": true,
+	"inc": true, "ltd": true, "llc": true, "gmbh": true, "ag": true, "eu": true, "sarl": true,
+	"mktplace": true, "mktp": true, "marketplace": true, "pos": true, "payment": true,
+}
+
+// payeeProcessors are payment processors that put their name in front of the
+// merchant, separated by an asterisk, e.g. "PAYPAL *NETFLIX"
+var payeeProcessors = map[string]bool{
+	"paypal": true, "sq": true, "sumup": true, "izettle": true, "zettle": true, "stripe": true,
+}
+
+type payee struct {
+	ID   string
+	Name string
+}
+
+// payeeDuplicate is a pair of payees that are probably the same
+type payeeDuplicate struct {
+	A          payee
+	B          payee
+	Similarity float64
+}
+
+// normalizePayeeName lower cases the name, drops payment processor prefixes,
+// punctuation, numbers and noise words, e.g. "AMAZON MKTPLACE" and
+// "Amazon.de" both become "amazon" and "PAYPAL *NETFLIX" becomes "netflix".
+func normalizePayeeName(name string) string {
+	if i := strings.Index(name, "*"); i > 0 && payeeProcessors[strings.ToLower(strings.TrimSpace(name[:i]))] {
+		name = name[i+1:]
+	}
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	var kept []string
+	for _, word := range words {
+		if !payeeNoise[word] {
+			kept = append(kept, word)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// payeeSimilarity compares normalised names, 1 means equal. A name that is
+// the beginning of the other one, like "rewe" and "rewe markt", counts as
+// similar as well.
+func payeeSimilarity(a string, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+	shorter, longer := a, b
+	if len(shorter) > len(longer) {
+		shorter, longer = longer, shorter
+	}
+	if len(shorter) >= 4 && strings.HasPrefix(longer, shorter+" ") {
+		return 0.9
+	}
+	return 1 - float64(levenshtein(a, b))/float64(len([]rune(longer)))
+}
+
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j] + 1
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+			if previous[j-1]+cost < current[j] {
+				current[j] = previous[j-1] + cost
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// findDuplicatePayees compares all payees with each other and returns the
+// pairs with a similarity of at least threshold, most similar first.
+func findDuplicatePayees(payees []payee, threshold float64) []payeeDuplicate {
+	normalized := make([]string, len(payees))
+	for i, p := range payees {
+		normalized[i] = normalizePayeeName(p.Name)
+	}
+
+	var duplicates []payeeDuplicate
+	for i := range payees {
+		for j := i + 1; j < len(payees); j++ {
+			if similarity := payeeSimilarity(normalized[i], normalized[j]); similarity >= threshold {
+				duplicates = append(duplicates, payeeDuplicate{A: payees[i], B: payees[j], Similarity: similarity})
+			}
+		}
+	}
+	sort.SliceStable(duplicates, func(i, j int) bool {
+		if duplicates[i].Similarity != duplicates[j].Similarity {
+			return duplicates[i].Similarity > duplicates[j].Similarity
+		}
+		return duplicates[i].A.Name < duplicates[j].A.Name
+	})
+	return duplicates
+}
+
+// loadPayees returns the payees that can be merged, transfer payees are
+// excluded.
+func loadPayees(ctx context.Context, tx *sql.Tx) ([]payee, error) {
+	res, err := tx.QueryContext(ctx, `
+		SELECT id, name
+		FROM payee
+		WHERE deleted <> 1 AND IFNULL(transfer_account_id, '') = ''
+		ORDER BY name, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var payees []payee
+	for res.Next() {
+		var p payee
+		if err := res.Scan(&p.ID, &p.Name); err != nil {
+			return nil, err
+		}
+		payees = append(payees, p)
+	}
+	return payees, res.Err()
+}
+
+func findPayee(ctx context.Context, tx *sql.Tx, nameOrID string) (payee, error) {
+	res, err := tx.QueryContext(ctx,
+		"SELECT id, name FROM payee WHERE (id = ? OR name = ?) AND deleted <> 1",
+		nameOrID, nameOrID)
+	if err != nil {
+		return payee{}, err
+	}
+	defer res.Close()
+
+	var payees []payee
+	for res.Next() {
+		var p payee
+		if err := res.Scan(&p.ID, &p.Name); err != nil {
+			return payee{}, err
+		}
+		payees = append(payees, p)
+	}
+	if err := res.Err(); err != nil {
+		return payee{}, err
+	}
+
+	switch len(payees) {
+	case 0:
+		return payee{}, fmt.Errorf("payee %q not found, run a sync first", nameOrID)
+	case 1:
+		return payees[0], nil
+	default:
+		return payee{}, fmt.Errorf("payee name %q is ambiguous, use the payee id", nameOrID)
+	}
+}
+
+// payeesReport ranks payees by the amount spent on them. Split transactions
+// are counted per subtransaction and transfers are left out. Payees that are
+// probably duplicates of another payee are listed in the similar column.
+func payeesReport(ctx context.Context, tx *sql.Tx, args []string) (report, string, error) {
+	flags := flag.NewFlagSet("report payees", flag.ExitOnError)
+	monthRange := monthRangeFlags(flags)
+	limit := flags.Int("limit", 20, "number of payees, 0 shows all")
+	threshold := flags.Float64("similarity", 0.85, "minimum similarity of payee names to flag them as duplicates")
+	format := flags.String("format", "table", "output format, table, csv, json or markdown")
+	flags.Parse(args)
+
+	from, to, err := monthRange()
+	if err != nil {
+		return report{}, "", err
+	}
+	if to != "" {
+		// include every day of the last month
+		to = to[:7] + "-31"
+	}
+	if *limit == 0 {
+		// a negative limit is no limit in SQLite
+		*limit = -1
+	}
+
+	payees, err := loadPayees(ctx, tx)
+	if err != nil {
+		return report{}, "", err
+	}
+	similar := make(map[string][]string)
+	for _, d := range findDuplicatePayees(payees, *threshold) {
+		similar[d.A.ID] = append(similar[d.A.ID], d.B.Name)
+		similar[d.B.ID] = append(similar[d.B.ID], d.A.Name)
+	}
+
+	res, err := tx.QueryContext(ctx, `
+		WITH line AS (
+			SELECT t.date, t.payee_id, t.payee_name, t.amount, t.transfer_account_id
+			FROM "transaction" t
+			WHERE t.deleted <> 1
+			AND NOT EXISTS(SELECT 1 FROM subtransaction s WHERE s.transaction_id = t.id AND s.deleted <> 1)
+			UNION ALL
+			SELECT t.date, IFNULL(s.payee_id, t.payee_id), IFNULL(s.payee_name, t.payee_name), s.amount, s.transfer_account_id
+			FROM subtransaction s
+			JOIN "transaction" t ON t.id = s.transaction_id
+			WHERE s.deleted <> 1 AND t.deleted <> 1
+		)
+		SELECT IFNULL(payee_id, ''), IFNULL(payee_name, ''), COUNT(*), -SUM(amount) AS spent
+		FROM line
+		WHERE IFNULL(transfer_account_id, '') = ''
+		AND IFNULL(payee_name, '') <> ''
+		AND (? = '' OR date >= ?) AND (? = '' OR date <= ?)
+		GROUP BY payee_id, payee_name
+		HAVING spent > 0
+		ORDER BY spent DESC, payee_name
+		LIMIT ?`,
+		from, from, to, to, *limit)
+	if err != nil {
+		return report{}, "", err
+	}
+	defer res.Close()
+
+	result := report{Columns: []string{"payee", "transactions", "spent", "similar"}}
+	for res.Next() {
+		var id, name string
+		var transactions, spent int
+		if err := res.Scan(&id, &name, &transactions, &spent); err != nil {
+			return report{}, "", err
+		}
+		result.add(name, transactions, amount(spent), strings.Join(similar[id], ", "))
+	}
+	if err := res.Err(); err != nil {
+		return report{}, "", err
+	}
+
+	return result, *format, nil
+}
+
+func payeesCommand(sqlite sqliteService, store storage, args []string) error {
+	usage := fmt.Errorf("usage: payees (duplicates|merge) [flags]")
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch args[0] {
+	case "duplicates":
+		return payeesDuplicatesCommand(sqlite, args[1:])
+	case "merge":
+		return payeesMergeCommand(sqlite, store, args[1:])
+	default:
+		return usage
+	}
+}
+
+func payeesDuplicatesCommand(sqlite sqliteService, args []string) error {
+	flags := flag.NewFlagSet("payees duplicates", flag.ExitOnError)
+	threshold := flags.Float64("similarity", 0.85, "minimum similarity of payee names")
+	flags.Parse(args)
+
+	return sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		payees, err := loadPayees(ctx, tx)
+		if err != nil {
+			return err
+		}
+		for _, d := range findDuplicatePayees(payees, *threshold) {
+			fmt.Printf("%.2f\t%s\t%s\t%s\t%s\n", d.Similarity, d.A.Name, d.B.Name, d.A.ID, d.B.ID)
+		}
+		return nil
+	})
+}
+
+// payeeMergeChanges re-points the transactions of payee from to payee into.
+// Subtransactions can't be changed through the API and keep their payee.
+func payeeMergeChanges(ctx context.Context, tx *sql.Tx, from payee, into payee) ([]transactionChange, error) {
+	transactions, err := loadEditableTransactions(ctx, tx, "t.payee_id = ?", from.ID)
+	if err != nil {
+		return nil, err
+	}
+	var changes []transactionChange
+	for _, t := range transactions {
+		id := into.ID
+		changes = append(changes, transactionChange{
+			Transaction: t,
+			Changes:     []fieldChange{{Field: "payee_id", Old: from.ID, New: into.ID}},
+			Update:      UpdateTransaction{ID: t.ID, PayeeID: &id},
+		})
+	}
+	return changes, nil
+}
+
+// payeesMergeCommand moves all transactions of a payee to another payee in
+// YNAB. The API can't delete payees, the old payee stays without
+// transactions.
+func payeesMergeCommand(sqlite sqliteService, store storage, args []string) error {
+	flags := flag.NewFlagSet("payees merge", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only show the transactions that would change")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("usage: payees merge [-dry-run] FROM INTO")
+	}
+
+	var from, into payee
+	var changes []transactionChange
+	err := sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		if from, err = findPayee(ctx, tx, flags.Arg(0)); err != nil {
+			return err
+		}
+		if into, err = findPayee(ctx, tx, flags.Arg(1)); err != nil {
+			return err
+		}
+		if from.ID == into.ID {
+			return fmt.Errorf("can't merge payee %q into itself", from.Name)
+		}
+		changes, err = payeeMergeChanges(ctx, tx, from, into)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		log.Printf("payee %q has no transactions", from.Name)
+		return nil
+	}
+	log.Printf("moving %d transactions from %q to %q", len(changes), from.Name, into.Name)
+	printTransactionChanges(changes, nil)
+	if *dryRun {
+		return nil
+	}
+
+	return applyTransactionChanges(ynabFromEnv(), sqlite, store, "payees merge", changes)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizePayeeName(t *testing.T) {
+	tests := map[string]string{
+		"AMAZON MKTPLACE":       "amazon",
+		"Amazon.de":             "amazon",
+		"REWE Markt GmbH 1234":  "rewe markt",
+		"www.spotify.com":       "spotify",
+		"Transfer : Checker":    "transfer checker",
+		"McDonald's Restaurant": "mcdonald s restaurant",
+		"PAYPAL *NETFLIX":       "netflix",
+		"SQ *CAFE ROSA":         "cafe rosa",
+		"PAYPAL":                "paypal",
+		"STAR*BUCKS":            "star bucks",
+	}
+	for name, want := range tests {
+		if got := normalizePayeeName(name); got != want {
+			t.Fatalf("normalizePayeeName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestPayeeSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"rewe", "rewe", 1},
+		{"rewe", "rewe markt", 0.9},
+		// a name contained later in the other one is not similar
+		{"netflix", "paypal netflix", 1 - 7.0/14},
+		{"rewe", "penny rewe", 1 - 6.0/10},
+		{"flix", "netflix", 1 - 3.0/7},
+		{"", "netflix", 0},
+	}
+	for _, test := range tests {
+		if got := payeeSimilarity(test.a, test.b); got != test.want {
+			t.Fatalf("payeeSimilarity(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+
+	// the processor prefix is dropped, PayPal itself is a different payee
+	if got := payeeSimilarity(normalizePayeeName("PayPal"), normalizePayeeName("PAYPAL *NETFLIX")); got > 0.5 {
+		t.Fatalf("similarity of PayPal and PAYPAL *NETFLIX = %v, want at most 0.5", got)
+	}
+	assertValue(t, "similarity", payeeSimilarity(normalizePayeeName("Netflix"), normalizePayeeName("PAYPAL *NETFLIX")), 1.0)
+}
+
+func TestFindDuplicatePayees(t *testing.T) {
+	payees := []payee{
+		{"1", "AMAZON MKTPLACE"},
+		{"2", "Amazon.de"},
+		{"3", "REWE"},
+		{"4", "Rewe Markt"},
+		{"5", "Netflix"},
+		{"6", "Netflx"},
+		{"7", "Lidl"},
+		{"8", "Aldi"},
+	}
+	duplicates := findDuplicatePayees(payees, 0.8)
+
+	var got []string
+	for _, d := range duplicates {
+		got = append(got, d.A.ID+"-"+d.B.ID)
+	}
+	if want := []string{"1-2", "3-4", "5-6"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("findDuplicatePayees = %v, want %v", got, want)
+	}
+	assertValue(t, "duplicates[0].Similarity", duplicates[0].Similarity, 1.0)
+}
+
+func TestPayeesReportAndMerge(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	mustExec(ctx, tx, t, `INSERT INTO payee (id, name, transfer_account_id, deleted) VALUES
+		('amazon', 'AMAZON MKTPLACE', NULL, 0),
+		('amazon-de', 'Amazon.de', NULL, 0),
+		('rent', 'Rent', NULL, 0),
+		('transfer', 'Transfer : Visa', 'visa', 0)`)
+	for _, row := range []struct {
+		id, date, payeeID, payeeName, transfer string
+		amount                                 int
+	}{
+		{"1", "2022-01-03", "amazon", "AMAZON MKTPLACE", "", -20000},
+		{"2", "2022-01-10", "amazon-de", "Amazon.de", "", -35000},
+		{"3", "2022-01-12", "amazon", "AMAZON MKTPLACE", "", 5000},
+		{"4", "2022-01-01", "rent", "Rent", "", -800000},
+		{"5", "2022-01-15", "transfer", "Transfer : Visa", "visa", -900000},
+		{"6", "2022-02-01", "rent", "Rent", "", -800000},
+	} {
+		mustExec(ctx, tx, t, `INSERT INTO "transaction" (id, date, amount, payee_id, payee_name, transfer_account_id, approved, deleted)
+			VALUES (?, ?, ?, ?, ?, NULLIF(?, ''), 1, 0)`,
+			row.id, row.date, row.amount, row.payeeID, row.payeeName, row.transfer)
+	}
+
+	r, _, err := payeesReport(ctx, tx, []string{"-to", "2022-01"})
+	if err != nil {
+		t.Fatalf("payeesReport err = %s, want nil", err)
+	}
+	want := [][]interface{}{
+		{"Rent", 1, amount(800000), ""},
+		{"Amazon.de", 1, amount(35000), "AMAZON MKTPLACE"},
+		{"AMAZON MKTPLACE", 2, amount(15000), "Amazon.de"},
+	}
+	if !reflect.DeepEqual(r.Rows, want) {
+		t.Fatalf("payeesReport = %v, want %v", r.Rows, want)
+	}
+	for limit, rows := range map[string]int{"1": 1, "0": 3} {
+		r, _, err := payeesReport(ctx, tx, []string{"-limit", limit})
+		if err != nil {
+			t.Fatalf("payeesReport -limit %s err = %s, want nil", limit, err)
+		}
+		assertInt(t, "len(rows) with -limit "+limit, len(r.Rows), rows)
+	}
+
+	from, err := findPayee(ctx, tx, "AMAZON MKTPLACE")
+	if err != nil {
+		t.Fatalf("findPayee err = %s, want nil", err)
+	}
+	into, err := findPayee(ctx, tx, "amazon-de")
+	if err != nil {
+		t.Fatalf("findPayee err = %s, want nil", err)
+	}
+	changes, err := payeeMergeChanges(ctx, tx, from, into)
+	if err != nil {
+		t.Fatalf("payeeMergeChanges err = %s, want nil", err)
+	}
+	assertInt(t, "len(changes)", len(changes), 2)
+	assertValue(t, "changes[0].Update.ID", changes[0].Update.ID, "1")
+	assertValue(t, "changes[0].Update.PayeeID", *changes[0].Update.PayeeID, "amazon-de")
+}
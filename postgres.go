@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+//go:embed schema_postgres.sql
+var postgresTables string
+
+// postgresStorage stores syncs in PostgreSQL, e.g. a shared warehouse. The
+// tables and the ledger view are those of SQLite with booleans, the change
+// log, tags and the search index are only kept in SQLite.
+type postgresStorage struct {
+	db      *sql.DB
+	created bool // the tables exist
+}
+
+// newPostgresStorage returns the database at url. It is only connected to
+// by the first sync, which creates the tables.
+func newPostgresStorage(url string) (*postgresStorage, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to PostgreSQL: %s", err)
+	}
+	return &postgresStorage{db: db}, nil
+}
+
+func (p *postgresStorage) Close() error {
+	return p.db.Close()
+}
+
+func (p *postgresStorage) Sync(ctx context.Context, fn func(context.Context, storageTx) error) error {
+	if !p.created {
+		if _, err := p.db.ExecContext(ctx, postgresTables); err != nil {
+			return fmt.Errorf("could not create PostgreSQL tables: %s", err)
+		}
+		p.created = true
+	}
+	return runTransaction(ctx, p.db, func(ctx context.Context, tx *sql.Tx) error {
+		return fn(ctx, &postgresTx{tx: tx, statements: make(map[string]*sql.Stmt)})
+	})
+}
+
+// postgresTx stores a sync in PostgreSQL
+type postgresTx struct {
+	tx         *sql.Tx
+	statements map[string]*sql.Stmt // upserts by table
+}
+
+// rebind replaces the ? placeholders of a query with $1, $2, ... Question
+// marks in string literals and quoted identifiers are kept.
+func rebind(query string) string {
+	var result strings.Builder
+	n := 0
+	var quote rune
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			// an escaped quote ends and starts the quoted section again
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			fmt.Fprintf(&result, "$%d", n)
+			continue
+		}
+		result.WriteRune(r)
+	}
+	return result.String()
+}
+
+// upsert inserts a row into table or updates the row with the same keys.
+// The keys have to be the first columns.
+func (p *postgresTx) upsert(ctx context.Context, table string, keys int, columns []string, values ...interface{}) error {
+	statement, ok := p.statements[table]
+	if !ok {
+		var placeholders, updates []string
+		for i, column := range columns {
+			placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
+			if i >= keys {
+				updates = append(updates, fmt.Sprintf(`"%s" = excluded."%s"`, column, column))
+			}
+		}
+		query := fmt.Sprintf(`INSERT INTO "%s" ("%s") VALUES (%s) ON CONFLICT ("%s") DO UPDATE SET %s`,
+			table, strings.Join(columns, `", "`), strings.Join(placeholders, ", "),
+			strings.Join(columns[:keys], `", "`), strings.Join(updates, ", "))
+		var err error
+		if statement, err = p.tx.PrepareContext(ctx, query); err != nil {
+			return err
+		}
+		p.statements[table] = statement
+	}
+	if _, err := statement.ExecContext(ctx, values...); err != nil {
+		return fmt.Errorf("could not store %s: %s", table, err)
+	}
+	return nil
+}
+
+func (p *postgresTx) updateServerKnowledge(ctx context.Context, endpoint string, value int) error {
+	return p.upsert(ctx, "server_knowledge", 1, []string{"endpoint", "value"}, endpoint, value)
+}
+
+func (p *postgresTx) LoadServerKnowledge(ctx context.Context) (map[string]int, error) {
+	serverKnowledge := make(map[string]int)
+	res, err := p.tx.QueryContext(ctx, "SELECT endpoint, COALESCE(value, 0) FROM server_knowledge")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	for res.Next() {
+		var endpoint string
+		var value int
+		if err := res.Scan(&endpoint, &value); err != nil {
+			return nil, err
+		}
+		serverKnowledge[endpoint] = value
+	}
+	return serverKnowledge, res.Err()
+}
+
+func (p *postgresTx) ChangeEvents(ctx context.Context, responses Responses) ([]changeEvent, error) {
+	queryRow := func(ctx context.Context, query string, args ...interface{}) *sql.Row {
+		return p.tx.QueryRowContext(ctx, rebind(query), args...)
+	}
+	return changeEvents(ctx, queryRow, responses)
+}
+
+func (p *postgresTx) StartSyncRun(ctx context.Context) (int64, error) {
+	var id int64
+	err := p.tx.QueryRowContext(ctx, "INSERT INTO sync_run (started_at) VALUES ($1) RETURNING id", time.Now().UTC()).Scan(&id)
+	return id, err
+}
+
+func (p *postgresTx) FinishSyncRun(ctx context.Context, id int64) error {
+	_, err := p.tx.ExecContext(ctx, "UPDATE sync_run SET finished_at = $1 WHERE id = $2", time.Now().UTC(), id)
+	return err
+}
+
+func (p *postgresTx) UpdateCategories(ctx context.Context, categories Categories) error {
+	for _, group := range categories.Data.CategoryGroups {
+		err := p.upsert(ctx, "category_group", 1, []string{"id", "name", "hidden", "deleted"},
+			group.ID, group.Name, group.Hidden, group.Deleted)
+		if err != nil {
+			return err
+		}
+		for _, category := range group.Categories {
+			err := p.upsert(ctx, "category", 1, []string{
+				"id", "name", "note", "category_group_id", "hidden", "deleted", "goal_type",
+				"goal_creation_month", "goal_target", "goal_target_month",
+			}, category.ID, category.Name, category.Note, category.CategoryGroupID, category.Hidden, category.Deleted,
+				category.GoalType, category.GoalCreationMonth, category.GoalTarget, category.GoalTargetMonth)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return p.updateServerKnowledge(ctx, "categories", categories.Data.ServerKnowledge)
+}
+
+func (p *postgresTx) UpdateMonthServerKnowledge(ctx context.Context, months Months) error {
+	return p.updateServerKnowledge(ctx, "months", months.Data.ServerKnowledge)
+}
+
+func (p *postgresTx) UpdateAccounts(ctx context.Context, accounts Accounts) error {
+	for _, account := range accounts.Data.Accounts {
+		err := p.upsert(ctx, "account", 1, []string{
+			"id", "name", "type", "on_budget", "closed", "note", "cleared_balance",
+			"uncleared_balane", "transfer_payee_id", "direct_import_linked",
+			"direct_import_in_error", "deleted",
+		}, account.ID, account.Name, account.Type, account.OnBudget, account.Closed, account.Note,
+			account.ClearedBalance, account.UnclearedBalance, account.TransferPayeeID,
+			account.DirectImportLinked, account.DirectImportInError, account.Deleted)
+		if err != nil {
+			return err
+		}
+	}
+	return p.updateServerKnowledge(ctx, "accounts", accounts.Data.ServerKnowledge)
+}
+
+func (p *postgresTx) UpdateTransactions(ctx context.Context, transactions Transactions) error {
+	for _, t := range transactions.Data.Transactions {
+		err := p.upsert(ctx, "transaction", 1, []string{
+			"id", "date", "amount", "memo", "cleared", "approved",
+			"flag_color", "account_id", "payee_id", "category_id",
+			"transfer_account_id", "transfer_transaction_id",
+			"matched_transaction_id", "import_id", "deleted",
+			"account_name", "payee_name", "category_name",
+		}, t.ID, t.Date, t.Amount, t.Memo, t.Cleared, t.Approved,
+			t.FlagColor, t.AccountID, t.PayeeID, t.CategoryID,
+			t.TransferAccountID, t.TransferTransactionID,
+			t.MatchedTransactionID, t.ImportID, t.Deleted,
+			t.AccountName, t.PayeeName, t.CategoryName)
+		if err != nil {
+			return err
+		}
+		for _, st := range t.Subtransactions {
+			err := p.upsert(ctx, "subtransaction", 1, []string{
+				"id", "transaction_id", "amount", "memo", "payee_id", "payee_name", "category_id",
+				"category_name", "transfer_account_id", "transfer_transaction_id", "deleted",
+			}, st.ID, st.TransactionID, st.Amount, st.Memo, st.PayeeID, st.PayeeName, st.CategoryID,
+				st.CategoryName, st.TransferAccountID, st.TransferTransactionID, st.Deleted)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return p.updateServerKnowledge(ctx, "transactions", transactions.Data.ServerKnowledge)
+}
+
+func (p *postgresTx) UpdateScheduledTransactions(ctx context.Context, scheduledTransactions ScheduledTransactions) error {
+	for _, t := range scheduledTransactions.Data.ScheduledTransactions {
+		err := p.upsert(ctx, "scheduled_transaction", 1, []string{
+			"id", "date_first", "date_next", "frequency", "amount", "memo",
+			"flag_color", "account_id", "payee_id", "category_id",
+			"transfer_account_id", "deleted",
+			"account_name", "payee_name", "category_name",
+		}, t.ID, t.DateFirst, t.DateNext, t.Frequency, t.Amount, t.Memo,
+			t.FlagColor, t.AccountID, t.PayeeID, t.CategoryID,
+			t.TransferAccountID, t.Deleted,
+			t.AccountName, t.PayeeName, t.CategoryName)
+		if err != nil {
+			return err
+		}
+	}
+	return p.updateServerKnowledge(ctx, "scheduled_transactions", scheduledTransactions.Data.ServerKnowledge)
+}
+
+func (p *postgresTx) UpdateMonth(ctx context.Context, month Month) error {
+	return p.upsert(ctx, "month", 1, []string{
+		"id", "note", "income", "budgeted", "activity", "to_be_budgeted", "age_of_money", "deleted",
+	}, month.Month, month.Note, month.Income, month.Budgeted, month.Activity,
+		month.ToBeBudgeted, month.AgeOfMoney, month.Deleted)
+}
+
+func (p *postgresTx) UpdateCategoryMonth(ctx context.Context, categoryMonth CategoryMonth) error {
+	for _, category := range categoryMonth.Data.Month.Categories {
+		err := p.upsert(ctx, "category_month", 2, []string{
+			"month_id", "category_id", "budgeted", "activity", "balance",
+		}, categoryMonth.Data.Month.Month.Month, category.ID, category.Budgeted, category.Activity, category.Balance)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *postgresTx) UpdatePayees(ctx context.Context, payees Payees) error {
+	for _, payee := range payees.Data.Payees {
+		err := p.upsert(ctx, "payee", 1, []string{"id", "name", "transfer_account_id", "deleted"},
+			payee.ID, payee.Name, payee.TransferAccountID, payee.Deleted)
+		if err != nil {
+			return err
+		}
+	}
+	return p.updateServerKnowledge(ctx, "payees", payees.Data.ServerKnowledge)
+}
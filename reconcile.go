@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// reconcileTransaction is a YNAB transaction that can be matched with a
+// statement line
+type reconcileTransaction struct {
+	ID        string
+	Date      string
+	Amount    int
+	PayeeName string
+	Cleared   string
+}
+
+type reconcileMatch struct {
+	Line        statementLine
+	Transaction reconcileTransaction
+}
+
+type reconciliation struct {
+	Matched               []reconcileMatch
+	UnmatchedLines        []statementLine
+	UnmatchedTransactions []reconcileTransaction
+}
+
+func daysBetween(a string, b string) int {
+	dateA, errA := time.Parse("2006-01-02", a)
+	dateB, errB := time.Parse("2006-01-02", b)
+	if errA != nil || errB != nil {
+		return -1
+	}
+	return abs(int(dateB.Sub(dateA).Hours() / 24))
+}
+
+// matchStatement pairs every statement line with the transaction of the same
+// amount that is closest in date and at most window days apart. Every
+// transaction is matched only once. Transactions outside of the period of
+// the statement are not reported as unmatched.
+func matchStatement(lines []statementLine, transactions []reconcileTransaction, window int) reconciliation {
+	var result reconciliation
+	if len(lines) == 0 {
+		return result
+	}
+
+	sorted := append([]statementLine(nil), lines...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Date < sorted[j].Date })
+	first, last := sorted[0].Date, sorted[len(sorted)-1].Date
+
+	matched := make([]bool, len(transactions))
+	for _, line := range sorted {
+		best := -1
+		for i, t := range transactions {
+			if matched[i] || t.Amount != line.Amount {
+				continue
+			}
+			distance := daysBetween(line.Date, t.Date)
+			if distance < 0 || distance > window {
+				continue
+			}
+			if best < 0 || distance < daysBetween(line.Date, transactions[best].Date) {
+				best = i
+			}
+		}
+		if best < 0 {
+			result.UnmatchedLines = append(result.UnmatchedLines, line)
+			continue
+		}
+		matched[best] = true
+		result.Matched = append(result.Matched, reconcileMatch{line, transactions[best]})
+	}
+
+	for i, t := range transactions {
+		if !matched[i] && t.Date >= first && t.Date <= last {
+			result.UnmatchedTransactions = append(result.UnmatchedTransactions, t)
+		}
+	}
+	return result
+}
+
+func loadReconcileTransactions(ctx context.Context, tx *sql.Tx, accountID string, from string, to string) ([]reconcileTransaction, error) {
+	res, err := tx.QueryContext(ctx, `
+		SELECT id, date, amount, IFNULL(payee_name, ''), IFNULL(cleared, '')
+		FROM "transaction"
+		WHERE account_id = ? AND deleted <> 1 AND date >= ? AND date <= ?
+		ORDER BY date, id`,
+		accountID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var transactions []reconcileTransaction
+	for res.Next() {
+		var t reconcileTransaction
+		if err := res.Scan(&t.ID, &t.Date, &t.Amount, &t.PayeeName, &t.Cleared); err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, res.Err()
+}
+
+func reconcileCommand(sqlite sqliteService, args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	account := flags.String("account", "", "name or id of the account the statement belongs to")
+	window := flags.Int("window", 3, "maximum number of days between booking and transaction date")
+	balance := flags.String("balance", "", "closing balance of the statement, compared with the cleared balance in YNAB (default: the ledger balance of OFX statements)")
+	statementMapping := csvMappingFlags(flags)
+	flags.Parse(args)
+
+	if *account == "" || flags.NArg() == 0 {
+		return fmt.Errorf("usage: reconcile -account NAME [flags] FILE...")
+	}
+	mapping := statementMapping()
+
+	var lines []statementLine
+	var statementBalance int
+	var balanceDate string
+	hasBalance := false
+	for _, path := range flags.Args() {
+		read, err := readStatementFile(path, mapping)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %s", path, err)
+		}
+		lines = append(lines, read...)
+
+		// the latest ledger balance of the statements closes them
+		fileBalance, date, ok, err := readStatementBalance(path)
+		if err != nil {
+			return fmt.Errorf("could not read the balance of %s: %s", path, err)
+		}
+		if ok && (!hasBalance || date >= balanceDate) {
+			statementBalance, balanceDate, hasBalance = fileBalance, date, true
+		}
+	}
+	if *balance != "" {
+		var err error
+		if statementBalance, err = parseAmount(*balance, mapping.DecimalSeparator); err != nil {
+			return fmt.Errorf("invalid balance %q: %s", *balance, err)
+		}
+		hasBalance = true
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("the statement has no bookings")
+	}
+
+	var result reconciliation
+	var clearedBalance int
+	err := sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		accountID, err := findAccount(ctx, tx, *account)
+		if err != nil {
+			return err
+		}
+		row := tx.QueryRowContext(ctx, "SELECT IFNULL(cleared_balance, 0) FROM account WHERE id = ?", accountID)
+		if err := row.Scan(&clearedBalance); err != nil {
+			return err
+		}
+
+		first, last := lines[0].Date, lines[0].Date
+		for _, line := range lines {
+			if line.Date < first {
+				first = line.Date
+			}
+			if line.Date > last {
+				last = line.Date
+			}
+		}
+		from, _ := time.Parse("2006-01-02", first)
+		to, _ := time.Parse("2006-01-02", last)
+		transactions, err := loadReconcileTransactions(ctx, tx, accountID,
+			from.AddDate(0, 0, -*window).Format("2006-01-02"), to.AddDate(0, 0, *window).Format("2006-01-02"))
+		if err != nil {
+			return err
+		}
+		result = matchStatement(lines, transactions, *window)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d of %d statement lines matched\n", len(result.Matched), len(lines))
+	if len(result.UnmatchedLines) > 0 {
+		fmt.Println("\nstatement lines missing in YNAB:")
+		for _, line := range result.UnmatchedLines {
+			fmt.Printf("\t%s\t%s\t%s\t%s\n", line.Date, formatAmount(line.Amount), line.Payee, line.Memo)
+		}
+	}
+	if len(result.UnmatchedTransactions) > 0 {
+		fmt.Println("\nYNAB transactions missing on the statement:")
+		for _, t := range result.UnmatchedTransactions {
+			fmt.Printf("\t%s\t%s\t%s\t%s\t%s\n", t.Date, formatAmount(t.Amount), t.PayeeName, t.Cleared, t.ID)
+		}
+	}
+	var uncleared []reconcileMatch
+	for _, match := range result.Matched {
+		if match.Transaction.Cleared == "uncleared" {
+			uncleared = append(uncleared, match)
+		}
+	}
+	if len(uncleared) > 0 {
+		fmt.Println("\nmatched transactions that are not cleared in YNAB:")
+		for _, match := range uncleared {
+			t := match.Transaction
+			fmt.Printf("\t%s\t%s\t%s\t%s\n", t.Date, formatAmount(t.Amount), t.PayeeName, t.ID)
+		}
+	}
+
+	if hasBalance {
+		fmt.Printf("\nstatement balance %s, cleared balance in YNAB %s", formatAmount(statementBalance), formatAmount(clearedBalance))
+		if difference := clearedBalance - statementBalance; difference != 0 {
+			fmt.Printf(", difference %s\n", formatAmount(difference))
+		} else {
+			fmt.Println(", balanced")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchStatement(t *testing.T) {
+	lines := []statementLine{
+		{Date: "2022-01-05", Amount: -23000, Payee: "Hugo"},
+		{Date: "2022-01-03", Amount: -23000, Payee: "Hugo"},
+		{Date: "2022-01-10", Amount: 1200500, Payee: "Employer"},
+		{Date: "2022-01-12", Amount: -9990, Payee: "Netflix"},
+	}
+	transactions := []reconcileTransaction{
+		{ID: "before", Date: "2021-12-31", Amount: -5000},
+		{ID: "hugo-1", Date: "2022-01-02", Amount: -23000},
+		{ID: "hugo-2", Date: "2022-01-06", Amount: -23000},
+		{ID: "salary", Date: "2022-01-15", Amount: 1200500},
+		{ID: "cash", Date: "2022-01-08", Amount: -50000},
+	}
+
+	result := matchStatement(lines, transactions, 3)
+
+	var matched []string
+	for _, match := range result.Matched {
+		matched = append(matched, match.Line.Date+" "+match.Transaction.ID)
+	}
+	if want := []string{"2022-01-03 hugo-1", "2022-01-05 hugo-2"}; !reflect.DeepEqual(matched, want) {
+		t.Fatalf("matched = %v, want %v", matched, want)
+	}
+	// the salary is 5 days off
+	assertInt(t, "len(UnmatchedLines)", len(result.UnmatchedLines), 2)
+	assertValue(t, "UnmatchedLines[0].Payee", result.UnmatchedLines[0].Payee, "Employer")
+	assertValue(t, "UnmatchedLines[1].Payee", result.UnmatchedLines[1].Payee, "Netflix")
+	// transactions before the statement period are not reported
+	var unmatched []string
+	for _, t := range result.UnmatchedTransactions {
+		unmatched = append(unmatched, t.ID)
+	}
+	if want := []string{"cash"}; !reflect.DeepEqual(unmatched, want) {
+		t.Fatalf("unmatched transactions = %v, want %v", unmatched, want)
+	}
+}
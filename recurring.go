@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// period is a detectable interval between recurring transactions
+type period struct {
+	Name      string
+	Days      float64
+	Tolerance float64 // days an occurrence may be off
+	next      func(time.Time) time.Time
+}
+
+var periods = []period{
+	{"weekly", 7, 1, func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }},
+	{"biweekly", 14, 2, func(t time.Time) time.Time { return t.AddDate(0, 0, 14) }},
+	{"monthly", 30.44, 4, func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+	{"quarterly", 91.31, 7, func(t time.Time) time.Time { return t.AddDate(0, 3, 0) }},
+	{"yearly", 365.25, 10, func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+}
+
+// occurrence is a single transaction of a recurring series
+type occurrence struct {
+	Date       time.Time
+	Amount     int
+	PayeeName  string
+	AccountID  string
+	CategoryID string
+}
+
+// recurringSeries is a row of the recurring_series table
+type recurringSeries struct {
+	PayeeName      string
+	AccountID      string
+	CategoryID     string
+	Period         string
+	Amount         int // of the last occurrence
+	PreviousAmount int
+	Occurrences    int
+	FirstDate      string
+	LastDate       string
+	NextDate       string
+	Missed         bool // the next occurrence is overdue
+	PriceChanged   bool // the last amount is outside the range of the earlier ones
+}
+
+// recurringOptions configure the detection
+type recurringOptions struct {
+	AmountTolerance float64 // relative difference of amounts within a series
+	PriceTolerance  float64 // relative difference that counts as a price change
+	MinOccurrences  int
+	MinRegularity   float64 // share of intervals that have to match the period
+}
+
+func defaultRecurringOptions() recurringOptions {
+	return recurringOptions{AmountTolerance: 0.2, PriceTolerance: 0.02, MinOccurrences: 3, MinRegularity: 0.7}
+}
+
+func loadOccurrences(ctx context.Context, tx *sql.Tx) ([]occurrence, error) {
+	res, err := tx.QueryContext(ctx, `
+		SELECT date, amount, payee_name, IFNULL(account_id, ''), IFNULL(category_id, '')
+		FROM "transaction"
+		WHERE deleted <> 1
+		AND amount <> 0
+		AND IFNULL(payee_name, '') <> ''
+		AND IFNULL(transfer_account_id, '') = ''
+		ORDER BY date`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var occurrences []occurrence
+	for res.Next() {
+		var o occurrence
+		var date string
+		if err := res.Scan(&date, &o.Amount, &o.PayeeName, &o.AccountID, &o.CategoryID); err != nil {
+			return nil, err
+		}
+		if o.Date, err = time.Parse("2006-01-02", date); err != nil {
+			return nil, err
+		}
+		occurrences = append(occurrences, o)
+	}
+	return occurrences, res.Err()
+}
+
+// clusterOccurrences groups occurrences by normalised payee name and similar
+// amounts. Every cluster is ordered by date.
+func clusterOccurrences(occurrences []occurrence, tolerance float64) [][]occurrence {
+	byPayee := make(map[string][]occurrence)
+	var payees []string
+	for _, o := range occurrences {
+		payee := strings.ToLower(strings.TrimSpace(o.PayeeName))
+		if _, ok := byPayee[payee]; !ok {
+			payees = append(payees, payee)
+		}
+		byPayee[payee] = append(byPayee[payee], o)
+	}
+	sort.Strings(payees)
+
+	var clusters [][]occurrence
+	for _, payee := range payees {
+		group := byPayee[payee]
+		sort.SliceStable(group, func(i, j int) bool { return group[i].Amount < group[j].Amount })
+
+		var current []occurrence
+		for _, o := range group {
+			// amounts are sorted, comparing with the first amount of the
+			// cluster keeps the spread of a cluster within the tolerance
+			if len(current) > 0 && !withinTolerance(current[0].Amount, o.Amount, tolerance) {
+				clusters = append(clusters, current)
+				current = nil
+			}
+			current = append(current, o)
+		}
+		clusters = append(clusters, current)
+	}
+
+	for _, cluster := range clusters {
+		sort.SliceStable(cluster, func(i, j int) bool { return cluster[i].Date.Before(cluster[j].Date) })
+	}
+	return clusters
+}
+
+func withinTolerance(a int, b int, tolerance float64) bool {
+	if (a < 0) != (b < 0) {
+		return false
+	}
+	return float64(abs(b-a)) <= tolerance*float64(abs(a))
+}
+
+// priceChanged reports if the last amount of the cluster lies outside the
+// spread of the earlier amounts by more than tolerance. Bills that vary a
+// little every time only count as changed once they leave their usual range.
+func priceChanged(cluster []occurrence, tolerance float64) bool {
+	last := cluster[len(cluster)-1].Amount
+	min, max := cluster[0].Amount, cluster[0].Amount
+	for _, o := range cluster[:len(cluster)-1] {
+		if o.Amount < min {
+			min = o.Amount
+		}
+		if o.Amount > max {
+			max = o.Amount
+		}
+	}
+	if last >= min && last <= max {
+		return false
+	}
+	return !withinTolerance(min, last, tolerance) && !withinTolerance(max, last, tolerance)
+}
+
+// detectPeriod returns the period most intervals between the occurrences
+// match.
+func detectPeriod(cluster []occurrence, minRegularity float64) (period, bool) {
+	if len(cluster) < 2 {
+		return period{}, false
+	}
+
+	var best period
+	var bestShare float64
+	for _, p := range periods {
+		matching := 0
+		for i := 1; i < len(cluster); i++ {
+			days := cluster[i].Date.Sub(cluster[i-1].Date).Hours() / 24
+			if days >= p.Days-p.Tolerance && days <= p.Days+p.Tolerance {
+				matching++
+			}
+		}
+		share := float64(matching) / float64(len(cluster)-1)
+		if share > bestShare {
+			best, bestShare = p, share
+		}
+	}
+	return best, bestShare >= minRegularity
+}
+
+// detectRecurring finds recurring series in the occurrences. today is used to
+// decide if the next occurrence was missed.
+func detectRecurring(occurrences []occurrence, options recurringOptions, today time.Time) []recurringSeries {
+	var series []recurringSeries
+	for _, cluster := range clusterOccurrences(occurrences, options.AmountTolerance) {
+		if len(cluster) < options.MinOccurrences {
+			continue
+		}
+		p, ok := detectPeriod(cluster, options.MinRegularity)
+		if !ok {
+			continue
+		}
+
+		last := cluster[len(cluster)-1]
+		previous := cluster[len(cluster)-2]
+		next := p.next(last.Date)
+		series = append(series, recurringSeries{
+			PayeeName:      last.PayeeName,
+			AccountID:      last.AccountID,
+			CategoryID:     last.CategoryID,
+			Period:         p.Name,
+			Amount:         last.Amount,
+			PreviousAmount: previous.Amount,
+			Occurrences:    len(cluster),
+			FirstDate:      cluster[0].Date.Format("2006-01-02"),
+			LastDate:       last.Date.Format("2006-01-02"),
+			NextDate:       next.Format("2006-01-02"),
+			Missed:         today.After(next.AddDate(0, 0, int(p.Tolerance))),
+			PriceChanged:   priceChanged(cluster, options.PriceTolerance),
+		})
+	}
+	return series
+}
+
+// updateRecurringSeries replaces the content of recurring_series.
+func updateRecurringSeries(ctx context.Context, tx *sql.Tx, series []recurringSeries) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recurring_series"); err != nil {
+		return err
+	}
+	statement, err := tx.Prepare(`
+		INSERT INTO recurring_series (
+			payee_name, account_id, category_id, period, amount, previous_amount,
+			occurrences, first_date, last_date, next_date, missed, price_changed
+		) VALUES (?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	for _, s := range series {
+		_, err := statement.ExecContext(ctx, s.PayeeName, s.AccountID, s.CategoryID, s.Period,
+			s.Amount, s.PreviousAmount, s.Occurrences, s.FirstDate, s.LastDate, s.NextDate,
+			s.Missed, s.PriceChanged)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recurringCommand(sqlite sqliteService, args []string) error {
+	options := defaultRecurringOptions()
+	flags := flag.NewFlagSet("recurring", flag.ExitOnError)
+	flags.Float64Var(&options.AmountTolerance, "tolerance", options.AmountTolerance, "relative amount difference within a series")
+	flags.Float64Var(&options.PriceTolerance, "price-tolerance", options.PriceTolerance, "relative amount difference that counts as a price change")
+	flags.IntVar(&options.MinOccurrences, "min-occurrences", options.MinOccurrences, "minimum number of transactions of a series")
+	flags.Float64Var(&options.MinRegularity, "regularity", options.MinRegularity, "share of intervals that have to match the period")
+	flags.Parse(args)
+
+	return sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		occurrences, err := loadOccurrences(ctx, tx)
+		if err != nil {
+			return err
+		}
+		series := detectRecurring(occurrences, options, time.Now())
+		if err := updateRecurringSeries(ctx, tx, series); err != nil {
+			return err
+		}
+
+		for _, s := range series {
+			var notes []string
+			if s.Missed {
+				notes = append(notes, "missed")
+			}
+			if s.PriceChanged {
+				notes = append(notes, fmt.Sprintf("price changed from %s", formatAmount(s.PreviousAmount)))
+			}
+			fmt.Printf("%s\t%s\t%s\t%dx since %s\tnext %s\t%s\n",
+				s.PayeeName, s.Period, formatAmount(s.Amount), s.Occurrences, s.FirstDate, s.NextDate,
+				strings.Join(notes, ", "))
+		}
+		return nil
+	})
+}
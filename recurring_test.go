@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func occurrencesOf(payee string, amounts []int, dates ...string) []occurrence {
+	var occurrences []occurrence
+	for i, date := range dates {
+		day, _ := time.Parse("2006-01-02", date)
+		occurrences = append(occurrences, occurrence{Date: day, Amount: amounts[i%len(amounts)], PayeeName: payee})
+	}
+	return occurrences
+}
+
+func TestClusterOccurrences(t *testing.T) {
+	var occurrences []occurrence
+	occurrences = append(occurrences, occurrencesOf("Netflix", []int{-12990}, "2022-01-15", "2022-02-15")...)
+	occurrences = append(occurrences, occurrencesOf("NETFLIX ", []int{-13990}, "2022-03-15")...)
+	occurrences = append(occurrences, occurrencesOf("Netflix", []int{-99000}, "2022-03-20")...)
+
+	clusters := clusterOccurrences(occurrences, 0.2)
+	assertInt(t, "len(clusters)", len(clusters), 2)
+	assertInt(t, "len(clusters[1])", len(clusters[1]), 3)
+	assertValue(t, "last date", clusters[1][2].Date.Format("2006-01-02"), "2022-03-15")
+}
+
+func TestDetectRecurring(t *testing.T) {
+	options := recurringOptions{AmountTolerance: 0.2, PriceTolerance: 0.02, MinOccurrences: 3, MinRegularity: 0.7}
+	var occurrences []occurrence
+	occurrences = append(occurrences, occurrencesOf("Spotify", []int{-9990}, "2022-01-03", "2022-02-03", "2022-03-03")...)
+	occurrences = append(occurrences, occurrencesOf("Spotify", []int{-10990}, "2022-04-03")...)
+	occurrences = append(occurrences, occurrencesOf("Gym", []int{-5000}, "2022-03-01", "2022-03-08", "2022-03-15", "2022-03-22")...)
+	occurrences = append(occurrences, occurrencesOf("Bakery", []int{-3000}, "2022-01-02", "2022-01-20", "2022-03-01")...)
+
+	today, _ := time.Parse("2006-01-02", "2022-04-10")
+	series := detectRecurring(occurrences, options, today)
+	assertInt(t, "len(series)", len(series), 2)
+
+	gym := series[0]
+	assertValue(t, "Period", gym.Period, "weekly")
+	assertValue(t, "NextDate", gym.NextDate, "2022-03-29")
+	assertValue(t, "Missed", gym.Missed, true)
+	assertValue(t, "PriceChanged", gym.PriceChanged, false)
+
+	spotify := series[1]
+	assertValue(t, "Period", spotify.Period, "monthly")
+	assertInt(t, "Occurrences", spotify.Occurrences, 4)
+	assertValue(t, "NextDate", spotify.NextDate, "2022-05-03")
+	assertValue(t, "Missed", spotify.Missed, false)
+	assertValue(t, "PriceChanged", spotify.PriceChanged, true)
+	assertInt(t, "PreviousAmount", spotify.PreviousAmount, -9990)
+}
+
+func TestPriceChanged(t *testing.T) {
+	tests := []struct {
+		amounts []int
+		want    bool
+	}{
+		{[]int{-9990, -9990, -9990, -10990}, true},
+		// electricity varies a little every month
+		{[]int{-5000, -5210, -4930, -5120}, false},
+		{[]int{-5000, -5210, -4930, -5250}, false},
+		{[]int{-5000, -5210, -4930, -5900}, true},
+		{[]int{-5000, -5210, -4930, -4000}, true},
+	}
+	for _, test := range tests {
+		dates := []string{"2022-01-01", "2022-02-01", "2022-03-01", "2022-04-01"}
+		if got := priceChanged(occurrencesOf("Power", test.amounts, dates...), 0.02); got != test.want {
+			t.Fatalf("priceChanged(%v) = %v, want %v", test.amounts, got, test.want)
+		}
+	}
+}
+
+func TestUpdateRecurringSeries(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	series := []recurringSeries{{PayeeName: "Spotify", Period: "monthly", Amount: -9990, NextDate: "2022-05-03"}}
+	for i := 0; i < 2; i++ {
+		if err := updateRecurringSeries(ctx, tx, series); err != nil {
+			t.Fatalf("updateRecurringSeries err = %s, want nil", err)
+		}
+	}
+	got := queryString(ctx, tx, "SELECT COUNT(*) || ' ' || next_date FROM recurring_series", t)
+	if want := "1 2022-05-03"; got != want {
+		t.Fatalf("%q != %q", got, want)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// amount is a value in milliunits. It is printed as decimal number in every
+// report format.
+type amount int
+
+func (a amount) String() string {
+	return formatAmount(int(a))
+}
+
+func (a amount) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatFloat(float64(a)/1000, 'f', -1, 64)), nil
+}
+
+// report is a table of values that can be written as table, CSV, JSON or
+// Markdown
+type report struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+func (r *report) add(values ...interface{}) {
+	r.Rows = append(r.Rows, values)
+}
+
+func (r report) write(w io.Writer, format string) error {
+	format = strings.ToLower(format)
+	cells := func(row []interface{}) []string {
+		var formatted []string
+		for _, value := range row {
+			if value == nil {
+				formatted = append(formatted, "")
+				continue
+			}
+			formatted = append(formatted, fmt.Sprint(value))
+		}
+		return formatted
+	}
+
+	switch format {
+	case "table":
+		writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, strings.Join(r.Columns, "\t"))
+		for _, row := range r.Rows {
+			fmt.Fprintln(writer, strings.Join(cells(row), "\t"))
+		}
+		return writer.Flush()
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write(r.Columns)
+		for _, row := range r.Rows {
+			writer.Write(cells(row))
+		}
+		writer.Flush()
+		return writer.Error()
+	case "json":
+		rows := make([]map[string]interface{}, 0, len(r.Rows))
+		for _, row := range r.Rows {
+			object := make(map[string]interface{})
+			for i, column := range r.Columns {
+				object[column] = row[i]
+			}
+			rows = append(rows, object)
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	case "markdown", "md":
+		fmt.Fprintf(w, "| %s |\n", strings.Join(r.Columns, " | "))
+		fmt.Fprintf(w, "|%s\n", strings.Repeat(" --- |", len(r.Columns)))
+		for _, row := range r.Rows {
+			escaped := cells(row)
+			for i := range escaped {
+				escaped[i] = strings.ReplaceAll(escaped[i], "|", "\\|")
+			}
+			fmt.Fprintf(w, "| %s |\n", strings.Join(escaped, " | "))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q, use table, csv, json or markdown", format)
+	}
+}
+
+func reportCommand(sqlite sqliteService, args []string) error {
+	reports := map[string]func(context.Context, *sql.Tx, []string) (report, string, error){
+		"budget":   budgetReport,
+		"cashflow": cashflowReport,
+		"networth": networthReport,
+		"payees":   payeesReport,
+		"tags":     tagsReport,
+	}
+	if len(args) == 0 || reports[args[0]] == nil {
+		var names []string
+		for name := range reports {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("usage: report (%s) [flags]", strings.Join(names, "|"))
+	}
+
+	var result report
+	var format string
+	err := sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		result, format, err = reports[args[0]](ctx, tx, args[1:])
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return result.write(os.Stdout, format)
+}
+
+// monthRangeFlags adds -from and -to to a report. Months are returned as
+// YYYY-MM-01, empty values don't limit the range.
+func monthRangeFlags(flags *flag.FlagSet) func() (string, string, error) {
+	from := flags.String("from", "", "first month (YYYY-MM)")
+	to := flags.String("to", "", "last month (YYYY-MM)")
+	return func() (string, string, error) {
+		var first, last string
+		var err error
+		if *from != "" {
+			if first, err = parseMonth(*from); err != nil {
+				return "", "", err
+			}
+		}
+		if *to != "" {
+			if last, err = parseMonth(*to); err != nil {
+				return "", "", err
+			}
+		}
+		return first, last, nil
+	}
+}
+
+// budgetReport shows budgeted, activity, balance and variance per category
+// and month. Rows without a category are the totals of the category group.
+// The variance is what is left of the amount budgeted in the month.
+func budgetReport(ctx context.Context, tx *sql.Tx, args []string) (report, string, error) {
+	flags := flag.NewFlagSet("report budget", flag.ExitOnError)
+	monthRange := monthRangeFlags(flags)
+	all := flags.Bool("all", false, "include hidden and deleted categories")
+	format := flags.String("format", "table", "output format, table, csv, json or markdown")
+	flags.Parse(args)
+
+	from, to, err := monthRange()
+	if err != nil {
+		return report{}, "", err
+	}
+
+	res, err := tx.QueryContext(ctx, `
+		SELECT
+			cm.month_id, g.name, c.name,
+			IFNULL(cm.budgeted, 0), IFNULL(cm.activity, 0), IFNULL(cm.balance, 0)
+		FROM category_month cm
+		JOIN category c ON c.id = cm.category_id
+		JOIN category_group g ON g.id = c.category_group_id
+		WHERE (? = '' OR cm.month_id >= ?)
+		AND (? = '' OR cm.month_id <= ?)
+		AND (? OR (c.hidden <> 1 AND c.deleted <> 1 AND g.hidden <> 1 AND g.deleted <> 1))
+		ORDER BY cm.month_id, g.name, c.name`,
+		from, from, to, to, *all)
+	if err != nil {
+		return report{}, "", err
+	}
+	defer res.Close()
+
+	result := report{Columns: []string{"month", "group", "category", "budgeted", "activity", "balance", "variance"}}
+	var month, group string
+	var groupBudgeted, groupActivity, groupBalance int
+	addGroupTotal := func() {
+		if group != "" {
+			result.add(month[:7], group, "", amount(groupBudgeted), amount(groupActivity),
+				amount(groupBalance), amount(groupBudgeted+groupActivity))
+		}
+		groupBudgeted, groupActivity, groupBalance = 0, 0, 0
+	}
+
+	for res.Next() {
+		var rowMonth, rowGroup, category string
+		var budgeted, activity, balance int
+		if err := res.Scan(&rowMonth, &rowGroup, &category, &budgeted, &activity, &balance); err != nil {
+			return report{}, "", err
+		}
+		if rowMonth != month || rowGroup != group {
+			addGroupTotal()
+			month, group = rowMonth, rowGroup
+		}
+		groupBudgeted += budgeted
+		groupActivity += activity
+		groupBalance += balance
+		result.add(month[:7], group, category, amount(budgeted), amount(activity), amount(balance), amount(budgeted+activity))
+	}
+	if err := res.Err(); err != nil {
+		return report{}, "", err
+	}
+	addGroupTotal()
+
+	return result, *format, nil
+}
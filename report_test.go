@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReportWrite(t *testing.T) {
+	r := report{Columns: []string{"month", "category", "activity"}}
+	r.add("2022-12", "Water | Sewage", amount(-23500))
+
+	tests := map[string]string{
+		"csv":      "month,category,activity\n2022-12,Water | Sewage,-23.50\n",
+		"json":     "[\n  {\n    \"activity\": -23.5,\n    \"category\": \"Water | Sewage\",\n    \"month\": \"2022-12\"\n  }\n]\n",
+		"markdown": "| month | category | activity |\n| --- | --- | --- |\n| 2022-12 | Water \\| Sewage | -23.50 |\n",
+		"table":    "month    category        activity\n2022-12  Water | Sewage  -23.50\n",
+	}
+	for format, want := range tests {
+		var buffer bytes.Buffer
+		if err := r.write(&buffer, format); err != nil {
+			t.Fatalf("write(%s) err = %s, want nil", format, err)
+		}
+		if got := buffer.String(); got != want {
+			t.Fatalf("write(%s) = %q, want %q", format, got, want)
+		}
+	}
+
+	if err := r.write(&bytes.Buffer{}, "xml"); err == nil {
+		t.Fatal("write(xml) err = nil, want error")
+	}
+}
+
+func TestBudgetReport(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	var categories Categories
+	loadFixture("./fixtures/categories.json", &categories, t)
+	if err := updateCategories(ctx, categories, tx); err != nil {
+		t.Fatalf("updateCategories err = %s, want nil", err)
+	}
+	for _, row := range []struct {
+		month, category             string
+		budgeted, activity, balance int
+	}{
+		{"2022-11-01", "7d3b19a3-a347-4a10-befc-b966f278aa3e", 30000, -23000, 7000},
+		{"2022-12-01", "7d3b19a3-a347-4a10-befc-b966f278aa3e", 30000, -40000, -3000},
+		{"2022-12-01", "cb19a998-9264-4255-a63c-349c586caeed", 40000, -39990, 10},
+	} {
+		mustExec(ctx, tx, t, `INSERT INTO category_month (month_id, category_id, budgeted, activity, balance) VALUES (?, ?, ?, ?, ?)`,
+			row.month, row.category, row.budgeted, row.activity, row.balance)
+	}
+	mustExec(ctx, tx, t, `UPDATE category SET hidden = 1 WHERE id = 'cb19a998-9264-4255-a63c-349c586caeed'`)
+
+	r, format, err := budgetReport(ctx, tx, []string{"-from", "2022-12", "-format", "csv"})
+	if err != nil {
+		t.Fatalf("budgetReport err = %s, want nil", err)
+	}
+	assertValue(t, "format", format, "csv")
+	var buffer bytes.Buffer
+	r.write(&buffer, format)
+	want := "month,group,category,budgeted,activity,balance,variance\n" +
+		"2022-12,Immediate Obligations,Water,30.00,-40.00,-3.00,-10.00\n" +
+		"2022-12,Immediate Obligations,,30.00,-40.00,-3.00,-10.00\n"
+	if got := buffer.String(); got != want {
+		t.Fatalf("budgetReport = %q, want %q", got, want)
+	}
+
+	r, _, err = budgetReport(ctx, tx, []string{"-all"})
+	if err != nil {
+		t.Fatalf("budgetReport err = %s, want nil", err)
+	}
+	assertInt(t, "len(r.Rows)", len(r.Rows), 5)
+}
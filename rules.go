@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+)
+
+// rule matches transactions and sets their category and/or payee. Empty
+// conditions match every transaction.
+type rule struct {
+	ID           int
+	Name         string
+	Priority     int
+	PayeePattern string // regular expression matched against payee_name
+	MemoPattern  string // regular expression matched against memo
+	MinAmount    *int   // milliunits, outflows are negative
+	MaxAmount    *int
+	AccountID    string
+	CategoryID   *string
+	PayeeName    *string
+
+	payee *regexp.Regexp
+	memo  *regexp.Regexp
+}
+
+func (r *rule) compile() error {
+	var err error
+	if r.PayeePattern != "" {
+		if r.payee, err = regexp.Compile(r.PayeePattern); err != nil {
+			return fmt.Errorf("invalid payee pattern of rule %q: %s", r.Name, err)
+		}
+	}
+	if r.MemoPattern != "" {
+		if r.memo, err = regexp.Compile(r.MemoPattern); err != nil {
+			return fmt.Errorf("invalid memo pattern of rule %q: %s", r.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r rule) matches(t editableTransaction) bool {
+	if r.payee != nil && !r.payee.MatchString(t.PayeeName) {
+		return false
+	}
+	if r.memo != nil && !r.memo.MatchString(t.Memo) {
+		return false
+	}
+	if r.MinAmount != nil && t.Amount < *r.MinAmount {
+		return false
+	}
+	if r.MaxAmount != nil && t.Amount > *r.MaxAmount {
+		return false
+	}
+	if r.AccountID != "" && r.AccountID != t.AccountID {
+		return false
+	}
+	return true
+}
+
+func (r rule) edit() transactionEdit {
+	return transactionEdit{CategoryID: r.CategoryID, PayeeName: r.PayeeName}
+}
+
+// loadRules returns all rules ordered by priority, highest priority first.
+func loadRules(ctx context.Context, tx *sql.Tx) ([]rule, error) {
+	res, err := tx.QueryContext(ctx, `
+		SELECT
+			id, name, priority, IFNULL(payee_pattern, ''), IFNULL(memo_pattern, ''),
+			min_amount, max_amount, IFNULL(account_id, ''), category_id, payee_name
+		FROM rule
+		ORDER BY priority DESC, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var rules []rule
+	for res.Next() {
+		var r rule
+		var minAmount, maxAmount sql.NullInt64
+		var categoryID, payeeName sql.NullString
+		err := res.Scan(&r.ID, &r.Name, &r.Priority, &r.PayeePattern, &r.MemoPattern,
+			&minAmount, &maxAmount, &r.AccountID, &categoryID, &payeeName)
+		if err != nil {
+			return nil, err
+		}
+		if minAmount.Valid {
+			value := int(minAmount.Int64)
+			r.MinAmount = &value
+		}
+		if maxAmount.Valid {
+			value := int(maxAmount.Int64)
+			r.MaxAmount = &value
+		}
+		if categoryID.Valid {
+			r.CategoryID = &categoryID.String
+		}
+		if payeeName.Valid {
+			r.PayeeName = &payeeName.String
+		}
+		if err := r.compile(); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, res.Err()
+}
+
+func insertRule(ctx context.Context, tx *sql.Tx, r rule) (int, error) {
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO rule (
+			name, priority, payee_pattern, memo_pattern, min_amount, max_amount,
+			account_id, category_id, payee_name
+		) VALUES (?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, NULLIF(?, ''), ?, ?)`,
+		r.Name, r.Priority, r.PayeePattern, r.MemoPattern, r.MinAmount, r.MaxAmount,
+		r.AccountID, r.CategoryID, r.PayeeName)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// proposeRuleChanges applies the first matching rule to every unapproved or
+// uncategorised transaction. Transfers are skipped, YNAB doesn't allow
+// categories on them.
+func proposeRuleChanges(ctx context.Context, tx *sql.Tx, rules []rule) ([]transactionChange, error) {
+	transactions, err := loadEditableTransactions(ctx, tx, `
+		IFNULL(t.transfer_account_id, '') = ''
+		AND (t.approved = 0 OR IFNULL(t.category_id, '') = '' OR t.category_name = 'Uncategorized')`)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []transactionChange
+	for _, t := range transactions {
+		for _, r := range rules {
+			if !r.matches(t) {
+				continue
+			}
+			if change := diffTransaction(t, r.edit()); len(change.Changes) > 0 {
+				changes = append(changes, change)
+			}
+			break
+		}
+	}
+	return changes, nil
+}
+
+// applyTransactionChangesLocally writes a change set to the transaction table
+// without sending it to YNAB. The changes are recorded in the change log.
+func applyTransactionChangesLocally(ctx context.Context, tx *sql.Tx, changes []transactionChange, categoryNames map[string]string) error {
+	transactionChanges, err := newChangeLog(ctx, tx, "transaction", "id")
+	if err != nil {
+		return err
+	}
+	statements := make(map[string]*sql.Stmt) // updates by field
+	update := func(field string, query string, args ...interface{}) error {
+		statement, ok := statements[field]
+		if !ok {
+			var err error
+			if statement, err = tx.PrepareContext(ctx, query); err != nil {
+				return err
+			}
+			statements[field] = statement
+		}
+		return transactionChanges.exec(ctx, statement, args...)
+	}
+
+	var ids []string
+	for _, change := range changes {
+		id := change.Transaction.ID
+		ids = append(ids, id)
+		for _, field := range change.Changes {
+			var err error
+			switch field.Field {
+			case "category_id":
+				err = update(field.Field, `UPDATE "transaction" SET category_id = ?2, category_name = ?3 WHERE id = ?1`,
+					id, field.New, categoryNames[field.New])
+			case "payee_name", "memo", "flag_color", "cleared":
+				err = update(field.Field, `UPDATE "transaction" SET `+field.Field+` = ?2 WHERE id = ?1`, id, field.New)
+			case "approved":
+				err = update(field.Field, `UPDATE "transaction" SET approved = ?2 WHERE id = ?1`, id, field.New == "true")
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err := updateSearchIndex(ctx, tx, ids); err != nil {
+		return err
+	}
+	return updateTransactionTags(ctx, tx, ids)
+}
+
+func rulesCommand(sqlite sqliteService, store storage, args []string) error {
+	usage := fmt.Errorf("usage: rules (add|list|delete|test|apply) [flags]")
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch args[0] {
+	case "add":
+		return rulesAddCommand(sqlite, args[1:])
+	case "list":
+		return rulesListCommand(sqlite)
+	case "delete":
+		return rulesDeleteCommand(sqlite, args[1:])
+	case "test":
+		return rulesTestCommand(sqlite, args[1:])
+	case "apply":
+		return rulesApplyCommand(sqlite, store, args[1:])
+	default:
+		return usage
+	}
+}
+
+func rulesAddCommand(sqlite sqliteService, args []string) error {
+	flags := flag.NewFlagSet("rules add", flag.ExitOnError)
+	name := flags.String("name", "", "name of the rule")
+	priority := flags.Int("priority", 0, "rules with a higher priority are checked first")
+	payeePattern := flags.String("payee", "", "regular expression matched against the payee name")
+	memoPattern := flags.String("memo", "", "regular expression matched against the memo")
+	minAmount := flags.String("min", "", "minimum amount, outflows are negative")
+	maxAmount := flags.String("max", "", "maximum amount, outflows are negative")
+	account := flags.String("account", "", "only match transactions of this account")
+	category := flags.String("set-category", "", "category to assign")
+	payee := flags.String("set-payee", "", "payee name to assign")
+	flags.Parse(args)
+
+	if *name == "" || (*category == "" && *payee == "") {
+		return fmt.Errorf("usage: rules add -name NAME [conditions] (-set-category CATEGORY | -set-payee PAYEE)")
+	}
+
+	r := rule{Name: *name, Priority: *priority, PayeePattern: *payeePattern, MemoPattern: *memoPattern}
+	if err := r.compile(); err != nil {
+		return err
+	}
+	for _, amount := range []struct {
+		value  string
+		target **int
+	}{{*minAmount, &r.MinAmount}, {*maxAmount, &r.MaxAmount}} {
+		if amount.value == "" {
+			continue
+		}
+		parsed, err := parseAmount(amount.value, ".")
+		if err != nil {
+			return err
+		}
+		*amount.target = &parsed
+	}
+	if *payee != "" {
+		r.PayeeName = payee
+	}
+
+	return sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		if *account != "" {
+			id, err := findAccount(ctx, tx, *account)
+			if err != nil {
+				return err
+			}
+			r.AccountID = id
+		}
+		if *category != "" {
+			id, _, err := findCategory(ctx, tx, *category)
+			if err != nil {
+				return err
+			}
+			r.CategoryID = &id
+		}
+		id, err := insertRule(ctx, tx, r)
+		if err != nil {
+			return err
+		}
+		log.Printf("added rule %d", id)
+		return nil
+	})
+}
+
+func rulesListCommand(sqlite sqliteService) error {
+	return sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		rules, err := loadRules(ctx, tx)
+		if err != nil {
+			return err
+		}
+		categoryNames, err := loadCategoryNames(ctx, tx)
+		if err != nil {
+			return err
+		}
+		for _, r := range rules {
+			fmt.Printf("%d\t%s\tpriority=%d", r.ID, r.Name, r.Priority)
+			if r.PayeePattern != "" {
+				fmt.Printf("\tpayee=/%s/", r.PayeePattern)
+			}
+			if r.MemoPattern != "" {
+				fmt.Printf("\tmemo=/%s/", r.MemoPattern)
+			}
+			if r.MinAmount != nil {
+				fmt.Printf("\tmin=%s", formatAmount(*r.MinAmount))
+			}
+			if r.MaxAmount != nil {
+				fmt.Printf("\tmax=%s", formatAmount(*r.MaxAmount))
+			}
+			if r.AccountID != "" {
+				fmt.Printf("\taccount=%s", r.AccountID)
+			}
+			if r.CategoryID != nil {
+				fmt.Printf("\t-> category %q", categoryNames[*r.CategoryID])
+			}
+			if r.PayeeName != nil {
+				fmt.Printf("\t-> payee %q", *r.PayeeName)
+			}
+			fmt.Println()
+		}
+		return nil
+	})
+}
+
+func rulesDeleteCommand(sqlite sqliteService, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: rules delete ID")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid rule id %q", args[0])
+	}
+	return sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM rule WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if deleted, _ := res.RowsAffected(); deleted == 0 {
+			return fmt.Errorf("rule %d not found", id)
+		}
+		return nil
+	})
+}
+
+// rulesTestCommand reports which transactions each rule would match,
+// regardless of whether they are approved or categorised already.
+func rulesTestCommand(sqlite sqliteService, args []string) error {
+	flags := flag.NewFlagSet("rules test", flag.ExitOnError)
+	limit := flags.Int("limit", 10, "number of matching transactions to show per rule, 0 shows all")
+	flags.Parse(args)
+
+	return sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		rules, err := loadRules(ctx, tx)
+		if err != nil {
+			return err
+		}
+		transactions, err := loadEditableTransactions(ctx, tx, "1 = 1")
+		if err != nil {
+			return err
+		}
+
+		for _, r := range rules {
+			var matches []editableTransaction
+			for _, t := range transactions {
+				if r.matches(t) {
+					matches = append(matches, t)
+				}
+			}
+			fmt.Printf("%d\t%s\t%d transactions\n", r.ID, r.Name, len(matches))
+			for i, t := range matches {
+				if *limit > 0 && i >= *limit {
+					fmt.Printf("\t...\n")
+					break
+				}
+				fmt.Printf("\t%s\t%s\t%s\t%s\t%s\n", t.Date, formatAmount(t.Amount), t.PayeeName, t.CategoryName, t.Memo)
+			}
+		}
+		return nil
+	})
+}
+
+func rulesApplyCommand(sqlite sqliteService, store storage, args []string) error {
+	flags := flag.NewFlagSet("rules apply", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only show the proposed changes")
+	push := flags.Bool("push", false, "send the changes to YNAB instead of only changing the local database")
+	flags.Parse(args)
+
+	var changes []transactionChange
+	var categoryNames map[string]string
+	err := sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		rules, err := loadRules(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if changes, err = proposeRuleChanges(ctx, tx, rules); err != nil {
+			return err
+		}
+		categoryNames, err = loadCategoryNames(ctx, tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		log.Print("no rule matches")
+		return nil
+	}
+	printTransactionChanges(changes, categoryNames)
+	if *dryRun {
+		return nil
+	}
+	if *push {
+		return applyTransactionChanges(ynabFromEnv(), sqlite, store, "rules", changes)
+	}
+
+	return sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		if err := applyTransactionChangesLocally(ctx, tx, changes, categoryNames); err != nil {
+			return err
+		}
+		return recordTransactionAudit(ctx, tx, "rules", changes)
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func prepareRulesDB(t *testing.T) (*sql.DB, context.Context, *sql.Tx) {
+	t.Helper()
+	db, ctx, tx := prepareDBTx(t)
+
+	var categories Categories
+	loadFixture("./fixtures/categories.json", &categories, t)
+	if err := updateCategories(ctx, categories, tx); err != nil {
+		t.Fatalf("updateCategories err = %s, want nil", err)
+	}
+	var transactions Transactions
+	loadFixture("./fixtures/transactions.json", &transactions, t)
+	if err := updateTransactions(ctx, transactions, tx); err != nil {
+		t.Fatalf("updateTransactions err = %s, want nil", err)
+	}
+	mustExec(ctx, tx, t, `INSERT INTO "transaction" (id, date, amount, memo, approved, account_id, payee_name, category_id, category_name, deleted)
+		VALUES ('new-1', '2021-12-01', -4500, 'weekly shopping', 0, '9a329f5e-1eca-40c6-8ba1-a19b0d8cadd1', 'REWE SAGT DANKE 123', NULL, NULL, 0)`)
+	mustExec(ctx, tx, t, `INSERT INTO "transaction" (id, date, amount, memo, approved, account_id, payee_name, category_id, category_name, deleted)
+		VALUES ('new-2', '2021-12-02', -99000, '', 0, '9a329f5e-1eca-40c6-8ba1-a19b0d8cadd1', 'REWE SAGT DANKE 456', NULL, NULL, 0)`)
+	return db, ctx, tx
+}
+
+func TestRuleMatches(t *testing.T) {
+	min, max := -50000, 0
+	r := rule{Name: "groceries", PayeePattern: "^REWE", MinAmount: &min, MaxAmount: &max}
+	if err := r.compile(); err != nil {
+		t.Fatalf("compile err = %s, want nil", err)
+	}
+
+	tests := []struct {
+		transaction editableTransaction
+		want        bool
+	}{
+		{editableTransaction{PayeeName: "REWE SAGT DANKE", Amount: -4500}, true},
+		{editableTransaction{PayeeName: "REWE SAGT DANKE", Amount: -99000}, false},
+		{editableTransaction{PayeeName: "EDEKA", Amount: -4500}, false},
+	}
+	for _, test := range tests {
+		if got := r.matches(test.transaction); got != test.want {
+			t.Fatalf("matches(%v) = %v, want %v", test.transaction, got, test.want)
+		}
+	}
+
+	invalid := rule{Name: "invalid", MemoPattern: "("}
+	if err := invalid.compile(); err == nil {
+		t.Fatal("compile err = nil, want error")
+	}
+}
+
+func TestProposeRuleChanges(t *testing.T) {
+	db, ctx, tx := prepareRulesDB(t)
+	defer db.Close()
+
+	groceries := "cb0d2e60-8d90-40d8-a3c6-f4963d27bc26"
+	max := 0
+	min := -50000
+	payee := "REWE"
+	if _, err := insertRule(ctx, tx, rule{Name: "rewe", PayeePattern: "^REWE", MinAmount: &min, MaxAmount: &max, CategoryID: &groceries, PayeeName: &payee}); err != nil {
+		t.Fatalf("insertRule err = %s, want nil", err)
+	}
+	// matches approved transactions only, which are never proposed
+	water := "7d3b19a3-a347-4a10-befc-b966f278aa3e"
+	if _, err := insertRule(ctx, tx, rule{Name: "hugo", PayeePattern: "Hugo", CategoryID: &water}); err != nil {
+		t.Fatalf("insertRule err = %s, want nil", err)
+	}
+
+	rules, err := loadRules(ctx, tx)
+	if err != nil {
+		t.Fatalf("loadRules err = %s, want nil", err)
+	}
+	assertInt(t, "len(rules)", len(rules), 2)
+
+	changes, err := proposeRuleChanges(ctx, tx, rules)
+	if err != nil {
+		t.Fatalf("proposeRuleChanges err = %s, want nil", err)
+	}
+	assertInt(t, "len(changes)", len(changes), 1)
+	assertValue(t, "Transaction.ID", changes[0].Transaction.ID, "new-1")
+	assertInt(t, "len(changes[0].Changes)", len(changes[0].Changes), 2)
+
+	categoryNames, err := loadCategoryNames(ctx, tx)
+	if err != nil {
+		t.Fatalf("loadCategoryNames err = %s, want nil", err)
+	}
+	if err := applyTransactionChangesLocally(ctx, tx, changes, categoryNames); err != nil {
+		t.Fatalf("applyTransactionChangesLocally err = %s, want nil", err)
+	}
+	got := queryString(ctx, tx, `SELECT category_name || '/' || payee_name FROM "transaction" WHERE id = 'new-1'`, t)
+	if want := "Groceries/REWE"; got != want {
+		t.Fatalf("%q != %q", got, want)
+	}
+	// every changed field is recorded in the change log
+	got = queryString(ctx, tx, `SELECT COUNT(*) || '/' || MIN(operation) FROM change_log WHERE table_name = 'transaction' AND primary_key = 'new-1'`, t)
+	if want := "2/update"; got != want {
+		t.Fatalf("change log = %q, want %q", got, want)
+	}
+}
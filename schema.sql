@@ -9,7 +9,8 @@ INSERT INTO server_knowledge(endpoint,value) VALUES
     ('accounts',     0),
     ('transactions', 0),
     ('payees',       0),
-    ('months',       0)
+    ('months',       0),
+    ('scheduled_transactions', 0)
 ON CONFLICT(endpoint) DO NOTHING;
 
 CREATE TABLE IF NOT EXISTS category_group (
@@ -73,6 +74,24 @@ CREATE TABLE IF NOT EXISTS "transaction" (
     category_name           TEXT
 );
 
+CREATE TABLE IF NOT EXISTS scheduled_transaction (
+    id                  TEXT NOT NULL PRIMARY KEY,
+    date_first          TEXT,
+    date_next           TEXT,
+    frequency           TEXT,
+    amount              INTEGER,
+    memo                TEXT,
+    flag_color          TEXT,
+    account_id          TEXT,
+    payee_id            TEXT,
+    category_id         TEXT,
+    transfer_account_id TEXT,
+    deleted             INTEGER,
+    account_name        TEXT,
+    payee_name          TEXT,
+    category_name       TEXT
+);
+
 CREATE TABLE IF NOT EXISTS subtransaction (
     id                      TEXT NOT NULL PRIMARY KEY,
     transaction_id          TEXT,
@@ -107,4 +126,139 @@ CREATE TABLE IF NOT EXISTS payee (
     name 				TEXT NOT NULL,
     transfer_account_id INTEGER,
     deleted				INTEGER
-);
\ No newline at end of file
+);
+
+CREATE TABLE IF NOT EXISTS transaction_audit (
+    id             INTEGER PRIMARY KEY,
+    changed_at     TEXT NOT NULL,
+    source         TEXT NOT NULL,
+    transaction_id TEXT NOT NULL,
+    field          TEXT NOT NULL,
+    old_value      TEXT,
+    new_value      TEXT
+);
+
+CREATE TABLE IF NOT EXISTS rule (
+    id            INTEGER PRIMARY KEY,
+    name          TEXT NOT NULL,
+    priority      INTEGER NOT NULL DEFAULT 0,
+    payee_pattern TEXT,
+    memo_pattern  TEXT,
+    min_amount    INTEGER,
+    max_amount    INTEGER,
+    account_id    TEXT,
+    category_id   TEXT,
+    payee_name    TEXT
+);
+
+CREATE TABLE IF NOT EXISTS recurring_series (
+    id              INTEGER PRIMARY KEY,
+    payee_name      TEXT NOT NULL,
+    account_id      TEXT,
+    category_id     TEXT,
+    period          TEXT NOT NULL,
+    amount          INTEGER,
+    previous_amount INTEGER,
+    occurrences     INTEGER,
+    first_date      TEXT,
+    last_date       TEXT,
+    next_date       TEXT,
+    missed          INTEGER,
+    price_changed   INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS age_of_money_daily (
+    date         TEXT NOT NULL PRIMARY KEY,
+    age_of_money INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS heartbeat (
+    name            TEXT NOT NULL PRIMARY KEY,
+    pid             INTEGER,
+    status          TEXT,
+    updated_at      TEXT,
+    last_success_at TEXT,
+    last_error      TEXT,
+    next_sync_at    TEXT
+);
+
+CREATE TABLE IF NOT EXISTS sync_run (
+    id          INTEGER PRIMARY KEY,
+    started_at  TEXT NOT NULL,
+    finished_at TEXT
+);
+
+CREATE TABLE IF NOT EXISTS change_log (
+    sequence    INTEGER PRIMARY KEY,
+    sync_run    INTEGER,
+    changed_at  TEXT NOT NULL,
+    table_name  TEXT NOT NULL,
+    primary_key TEXT NOT NULL,
+    operation   TEXT NOT NULL,
+    old_value   TEXT,
+    new_value   TEXT
+);
+
+CREATE TABLE IF NOT EXISTS tag (
+    id   INTEGER PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS transaction_tag (
+    transaction_id    TEXT NOT NULL,
+    subtransaction_id TEXT NOT NULL DEFAULT '',
+    tag_id            INTEGER NOT NULL,
+    PRIMARY KEY (transaction_id, subtransaction_id, tag_id)
+);
+
+-- ledger has a row per posting: transactions, but split transactions are
+-- replaced by their subtransactions. The view is created again on every start
+-- to pick up changes.
+DROP VIEW IF EXISTS ledger;
+CREATE VIEW ledger AS
+SELECT
+    l.transaction_id,
+    l.subtransaction_id,
+    l.date,
+    l.account_id,
+    IFNULL(a.name, l.account_name)   AS account_name,
+    IFNULL(a.on_budget, 0)           AS on_budget,
+    l.payee_id,
+    IFNULL(p.name, l.payee_name)     AS payee_name,
+    l.category_id,
+    IFNULL(c.name, l.category_name)  AS category_name,
+    c.category_group_id,
+    g.name                           AS category_group_name,
+    l.memo,
+    l.amount,
+    CAST(l.amount AS REAL) / 1000    AS decimal_amount,
+    l.cleared,
+    l.approved,
+    l.flag_color,
+    l.transfer_account_id,
+    transfer.name                    AS transfer_account_name,
+    IFNULL(l.transfer_account_id, '') <> '' AS transfer
+FROM (
+    SELECT
+        t.id AS transaction_id, NULL AS subtransaction_id, t.date, t.account_id, t.account_name,
+        t.payee_id, t.payee_name, t.category_id, t.category_name, t.memo, t.amount,
+        t.cleared, t.approved, t.flag_color, NULLIF(t.transfer_account_id, '') AS transfer_account_id
+    FROM "transaction" t
+    WHERE t.deleted <> 1
+    AND NOT EXISTS (SELECT 1 FROM subtransaction s WHERE s.transaction_id = t.id AND s.deleted <> 1)
+    UNION ALL
+    SELECT
+        t.id, s.id, t.date, t.account_id, t.account_name,
+        IFNULL(NULLIF(s.payee_id, ''), t.payee_id), IFNULL(NULLIF(s.payee_name, ''), t.payee_name),
+        s.category_id, s.category_name, IFNULL(NULLIF(s.memo, ''), t.memo), s.amount,
+        t.cleared, t.approved, t.flag_color, NULLIF(s.transfer_account_id, '')
+    FROM subtransaction s
+    JOIN "transaction" t ON t.id = s.transaction_id
+    WHERE s.deleted <> 1 AND t.deleted <> 1
+) l
+LEFT JOIN account a ON a.id = l.account_id
+LEFT JOIN payee p ON p.id = l.payee_id
+LEFT JOIN category c ON c.id = l.category_id
+LEFT JOIN category_group g ON g.id = c.category_group_id
+LEFT JOIN account transfer ON transfer.id = l.transfer_account_id
+WHERE IFNULL(a.deleted, 0) <> 1;
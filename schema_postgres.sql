@@ -0,0 +1,186 @@
+CREATE TABLE IF NOT EXISTS server_knowledge (
+    endpoint TEXT NOT NULL PRIMARY KEY,
+    value    BIGINT
+);
+
+INSERT INTO server_knowledge(endpoint, value) VALUES
+    ('categories',   0),
+    ('accounts',     0),
+    ('transactions', 0),
+    ('payees',       0),
+    ('months',       0),
+    ('scheduled_transactions', 0)
+ON CONFLICT(endpoint) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS category_group (
+    id      TEXT NOT NULL PRIMARY KEY,
+    name    TEXT NOT NULL,
+    hidden  BOOLEAN,
+    deleted BOOLEAN
+);
+
+CREATE TABLE IF NOT EXISTS category (
+    id                  TEXT NOT NULL PRIMARY KEY,
+    category_group_id   TEXT NOT NULL,
+    name                TEXT NOT NULL,
+    note                TEXT,
+    hidden              BOOLEAN,
+    deleted             BOOLEAN,
+    goal_type           TEXT,
+    goal_creation_month TEXT,
+    goal_target         BIGINT,
+    goal_target_month   TEXT
+);
+
+CREATE TABLE IF NOT EXISTS month (
+    id             TEXT NOT NULL PRIMARY KEY,
+    note           TEXT,
+    income         BIGINT,
+    budgeted       BIGINT,
+    activity       BIGINT,
+    to_be_budgeted BIGINT,
+    age_of_money   INTEGER,
+    deleted        BOOLEAN
+);
+
+CREATE TABLE IF NOT EXISTS category_month (
+    month_id    TEXT NOT NULL,
+    category_id TEXT NOT NULL,
+    budgeted    BIGINT,
+    activity    BIGINT,
+    balance     BIGINT,
+    PRIMARY KEY (month_id, category_id)
+);
+
+CREATE TABLE IF NOT EXISTS "transaction" (
+    id                      TEXT NOT NULL PRIMARY KEY,
+    date                    TEXT,
+    amount                  BIGINT,
+    memo                    TEXT,
+    cleared                 TEXT,
+    approved                BOOLEAN,
+    flag_color              TEXT,
+    account_id              TEXT,
+    payee_id                TEXT,
+    category_id             TEXT,
+    transfer_account_id     TEXT,
+    transfer_transaction_id TEXT,
+    matched_transaction_id  TEXT,
+    import_id               TEXT,
+    deleted                 BOOLEAN,
+    account_name            TEXT,
+    payee_name              TEXT,
+    category_name           TEXT
+);
+
+CREATE TABLE IF NOT EXISTS scheduled_transaction (
+    id                  TEXT NOT NULL PRIMARY KEY,
+    date_first          TEXT,
+    date_next           TEXT,
+    frequency           TEXT,
+    amount              BIGINT,
+    memo                TEXT,
+    flag_color          TEXT,
+    account_id          TEXT,
+    payee_id            TEXT,
+    category_id         TEXT,
+    transfer_account_id TEXT,
+    deleted             BOOLEAN,
+    account_name        TEXT,
+    payee_name          TEXT,
+    category_name       TEXT
+);
+
+CREATE TABLE IF NOT EXISTS subtransaction (
+    id                      TEXT NOT NULL PRIMARY KEY,
+    transaction_id          TEXT,
+    amount                  BIGINT,
+    memo                    TEXT,
+    payee_id                TEXT,
+    payee_name              TEXT,
+    category_id             TEXT,
+    category_name           TEXT,
+    transfer_account_id     TEXT,
+    transfer_transaction_id TEXT,
+    deleted                 BOOLEAN
+);
+
+CREATE TABLE IF NOT EXISTS account (
+    id                     TEXT NOT NULL PRIMARY KEY,
+    name                   TEXT,
+    type                   TEXT,
+    on_budget              BOOLEAN,
+    closed                 BOOLEAN,
+    note                   TEXT,
+    cleared_balance        BIGINT,
+    uncleared_balane       BIGINT,
+    transfer_payee_id      TEXT,
+    direct_import_linked   BOOLEAN,
+    direct_import_in_error BOOLEAN,
+    deleted                BOOLEAN
+);
+
+CREATE TABLE IF NOT EXISTS payee (
+    id                  TEXT NOT NULL PRIMARY KEY,
+    name                TEXT NOT NULL,
+    transfer_account_id TEXT,
+    deleted             BOOLEAN
+);
+
+CREATE TABLE IF NOT EXISTS sync_run (
+    id          BIGSERIAL PRIMARY KEY,
+    started_at  TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ
+);
+
+-- ledger is the view of SQLite: a row per posting, split transactions are
+-- replaced by their subtransactions. It is created again by the first sync of
+-- every start to pick up changes.
+DROP VIEW IF EXISTS ledger;
+CREATE VIEW ledger AS
+SELECT
+    l.transaction_id,
+    l.subtransaction_id,
+    l.date,
+    l.account_id,
+    COALESCE(a.name, l.account_name)      AS account_name,
+    COALESCE(a.on_budget, FALSE)          AS on_budget,
+    l.payee_id,
+    COALESCE(p.name, l.payee_name)        AS payee_name,
+    l.category_id,
+    COALESCE(c.name, l.category_name)     AS category_name,
+    c.category_group_id,
+    g.name                                AS category_group_name,
+    l.memo,
+    l.amount,
+    CAST(l.amount AS DOUBLE PRECISION) / 1000 AS decimal_amount,
+    l.cleared,
+    l.approved,
+    l.flag_color,
+    l.transfer_account_id,
+    transfer.name                         AS transfer_account_name,
+    COALESCE(l.transfer_account_id, '') <> '' AS transfer
+FROM (
+    SELECT
+        t.id AS transaction_id, NULL AS subtransaction_id, t.date, t.account_id, t.account_name,
+        t.payee_id, t.payee_name, t.category_id, t.category_name, t.memo, t.amount,
+        t.cleared, t.approved, t.flag_color, NULLIF(t.transfer_account_id, '') AS transfer_account_id
+    FROM "transaction" t
+    WHERE t.deleted <> TRUE
+    AND NOT EXISTS (SELECT 1 FROM subtransaction s WHERE s.transaction_id = t.id AND s.deleted <> TRUE)
+    UNION ALL
+    SELECT
+        t.id, s.id, t.date, t.account_id, t.account_name,
+        COALESCE(NULLIF(s.payee_id, ''), t.payee_id), COALESCE(NULLIF(s.payee_name, ''), t.payee_name),
+        s.category_id, s.category_name, COALESCE(NULLIF(s.memo, ''), t.memo), s.amount,
+        t.cleared, t.approved, t.flag_color, NULLIF(s.transfer_account_id, '')
+    FROM subtransaction s
+    JOIN "transaction" t ON t.id = s.transaction_id
+    WHERE s.deleted <> TRUE AND t.deleted <> TRUE
+) l
+LEFT JOIN account a ON a.id = l.account_id
+LEFT JOIN payee p ON p.id = l.payee_id
+LEFT JOIN category c ON c.id = l.category_id
+LEFT JOIN category_group g ON g.id = c.category_group_id
+LEFT JOIN account transfer ON transfer.id = l.transfer_account_id
+WHERE COALESCE(a.deleted, FALSE) <> TRUE;
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// errNoSearchIndex is returned when SQLite is built without FTS5
+var errNoSearchIndex = errors.New("full-text search is not available, SQLite is built without FTS5 (build with make or go build -tags sqlite_fts5)")
+
+// searchIndex is an FTS5 table with a row per transaction. The memos, payees
+// and categories of subtransactions are added to those of the transaction.
+const searchIndex = `CREATE VIRTUAL TABLE IF NOT EXISTS transaction_search USING fts5(
+	transaction_id UNINDEXED, memo, payee_name, category_name, account_name
+)`
+
+// indexTransactions adds transactions to the search index, the placeholder is
+// a query of their ids
+const indexTransactions = `
+	INSERT INTO transaction_search (transaction_id, memo, payee_name, category_name, account_name)
+	SELECT t.id,
+		TRIM(IFNULL(t.memo, '') || ' ' || IFNULL(group_concat(s.memo, ' '), '')),
+		TRIM(IFNULL(t.payee_name, '') || ' ' || IFNULL(group_concat(s.payee_name, ' '), '')),
+		TRIM(IFNULL(t.category_name, '') || ' ' || IFNULL(group_concat(s.category_name, ' '), '')),
+		IFNULL(t.account_name, '')
+	FROM "transaction" t
+	LEFT JOIN subtransaction s ON s.transaction_id = t.id AND s.deleted <> 1
+	WHERE t.id IN (%s) AND t.deleted <> 1
+	GROUP BY t.id`
+
+// createSearchIndex creates the search index if SQLite supports FTS5 and
+// fills it with the transactions that are already stored
+func createSearchIndex(db *sql.DB) error {
+	var available, exists bool
+	err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5'),
+		EXISTS(SELECT 1 FROM sqlite_master WHERE name = 'transaction_search')`).Scan(&available, &exists)
+	if err != nil || !available || exists {
+		return err
+	}
+	if _, err := db.Exec(searchIndex); err != nil {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf(indexTransactions, `SELECT id FROM "transaction"`))
+	return err
+}
+
+// searchIndexAvailable tells whether the search index exists and can be used
+func searchIndexAvailable(ctx context.Context, tx *sql.Tx) (bool, error) {
+	var available bool
+	err := tx.QueryRowContext(ctx, `SELECT sqlite_compileoption_used('ENABLE_FTS5')
+		AND EXISTS(SELECT 1 FROM sqlite_master WHERE name = 'transaction_search')`).Scan(&available)
+	return available, err
+}
+
+// updateSearchIndex indexes the transactions again, e.g. after they were
+// written by a sync. Nothing happens if there is no search index.
+func updateSearchIndex(ctx context.Context, tx *sql.Tx, transactionIDs []string) error {
+	if len(transactionIDs) == 0 {
+		return nil
+	}
+	available, err := searchIndexAvailable(ctx, tx)
+	if err != nil || !available {
+		return err
+	}
+	ids, err := json.Marshal(transactionIDs)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM transaction_search WHERE transaction_id IN (SELECT value FROM json_each(?))`, string(ids))
+	if err != nil {
+		return fmt.Errorf("could not update search index: %s", err)
+	}
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf(indexTransactions, "SELECT value FROM json_each(?)"), string(ids)); err != nil {
+		return fmt.Errorf("could not update search index: %s", err)
+	}
+	return nil
+}
+
+// rebuildSearchIndex indexes all transactions again
+func rebuildSearchIndex(ctx context.Context, tx *sql.Tx) error {
+	available, err := searchIndexAvailable(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if !available {
+		return errNoSearchIndex
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM transaction_search`); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(indexTransactions, `SELECT id FROM "transaction"`))
+	return err
+}
+
+// searchOptions limit the results of a search. Dates are YYYY-MM-DD, amounts
+// are absolute values in milliunits, nil doesn't limit.
+type searchOptions struct {
+	From, To  string
+	Min, Max  *int
+	AccountID string
+	Limit     int
+}
+
+type searchResult struct {
+	ID       string
+	Date     string
+	Account  string
+	Payee    string
+	Category string
+	Memo     string
+	Amount   int
+}
+
+// searchTransactions finds the transactions matching an FTS5 query, best
+// matches first
+func searchTransactions(ctx context.Context, tx *sql.Tx, query string, options searchOptions) ([]searchResult, error) {
+	available, err := searchIndexAvailable(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if !available {
+		return nil, errNoSearchIndex
+	}
+
+	res, err := tx.QueryContext(ctx, `
+		SELECT t.id, t.date, IFNULL(t.account_name, ''), search.payee_name, search.category_name, search.memo, t.amount
+		FROM transaction_search search
+		JOIN "transaction" t ON t.id = search.transaction_id
+		WHERE transaction_search MATCH ?
+		AND (? = '' OR t.date >= ?) AND (? = '' OR t.date <= ?)
+		AND (? IS NULL OR ABS(t.amount) >= ?) AND (? IS NULL OR ABS(t.amount) <= ?)
+		AND (? = '' OR t.account_id = ?)
+		ORDER BY search.rank, t.date DESC
+		LIMIT ?`,
+		query, options.From, options.From, options.To, options.To,
+		options.Min, options.Min, options.Max, options.Max,
+		options.AccountID, options.AccountID, options.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not search for %q: %s", query, err)
+	}
+	defer res.Close()
+
+	var results []searchResult
+	for res.Next() {
+		var r searchResult
+		if err := res.Scan(&r.ID, &r.Date, &r.Account, &r.Payee, &r.Category, &r.Memo, &r.Amount); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, res.Err()
+}
+
+func searchCommand(sqlite sqliteService, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	from := flags.String("from", "", "first date (YYYY-MM-DD)")
+	to := flags.String("to", "", "last date (YYYY-MM-DD)")
+	minAmount := flags.String("min", "", "smallest absolute amount")
+	maxAmount := flags.String("max", "", "largest absolute amount")
+	accountName := flags.String("account", "", "only search this account")
+	limit := flags.Int("limit", 20, "maximum number of results")
+	rebuild := flags.Bool("rebuild", false, "index all transactions again")
+	format := flags.String("format", "table", "output format, table, csv, json or markdown")
+	flags.Parse(args)
+
+	query := strings.Join(flags.Args(), " ")
+	if query == "" && !*rebuild {
+		return fmt.Errorf("usage: search [flags] QUERY")
+	}
+	options := searchOptions{From: *from, To: *to, Limit: *limit}
+	for _, bound := range []struct {
+		value  string
+		target **int
+	}{{*minAmount, &options.Min}, {*maxAmount, &options.Max}} {
+		if bound.value == "" {
+			continue
+		}
+		value, err := parseAmount(bound.value, ".")
+		if err != nil {
+			return err
+		}
+		value = abs(value)
+		*bound.target = &value
+	}
+
+	var results []searchResult
+	err := sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		if *rebuild {
+			if err := rebuildSearchIndex(ctx, tx); err != nil {
+				return fmt.Errorf("could not rebuild search index: %s", err)
+			}
+		}
+		if query == "" {
+			return nil
+		}
+		if *accountName != "" {
+			accountID, err := findAccount(ctx, tx, *accountName)
+			if err != nil {
+				return err
+			}
+			options.AccountID = accountID
+		}
+		var err error
+		results, err = searchTransactions(ctx, tx, query, options)
+		return err
+	})
+	if err != nil || query == "" {
+		return err
+	}
+
+	result := report{Columns: []string{"date", "account", "payee", "category", "memo", "amount", "id"}}
+	for _, r := range results {
+		result.add(r.Date, r.Account, r.Payee, r.Category, r.Memo, amount(r.Amount), r.ID)
+	}
+	return result.write(os.Stdout, *format)
+}
@@ -0,0 +1,26 @@
+//go:build !sqlite_fts5
+
+package main
+
+import (
+	"testing"
+)
+
+func TestSearchWithoutFTS5(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	if available, err := searchIndexAvailable(ctx, tx); err != nil || available {
+		t.Fatalf("searchIndexAvailable = %v, %v, want false without -tags sqlite_fts5", available, err)
+	}
+	// syncs don't need the index
+	if err := updateSearchIndex(ctx, tx, []string{"1"}); err != nil {
+		t.Fatalf("updateSearchIndex err = %s, want nil", err)
+	}
+	if _, err := searchTransactions(ctx, tx, "coffee", searchOptions{Limit: 10}); err != errNoSearchIndex {
+		t.Fatalf("searchTransactions err = %v, want %v", err, errNoSearchIndex)
+	}
+	if err := rebuildSearchIndex(ctx, tx); err != errNoSearchIndex {
+		t.Fatalf("rebuildSearchIndex err = %v, want %v", err, errNoSearchIndex)
+	}
+}
@@ -0,0 +1,117 @@
+//go:build sqlite_fts5
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+// prepareSearchDB stores the transaction fixture
+func prepareSearchDB(t *testing.T) (*sql.DB, context.Context, *sql.Tx, Transactions) {
+	db, ctx, tx := prepareDBTx(t)
+	if available, err := searchIndexAvailable(ctx, tx); err != nil || !available {
+		db.Close()
+		t.Fatalf("searchIndexAvailable = %v, %v, want true with -tags sqlite_fts5", available, err)
+	}
+
+	var transactions Transactions
+	loadFixture("./fixtures/transactions.json", &transactions, t)
+	transactions.Data.Transactions[0].Memo = "bottled water for the office"
+	transactions.Data.Transactions[3].Subtransactions[1].Memo = "electricity bill"
+	if err := updateTransactions(ctx, transactions, tx); err != nil {
+		t.Fatalf("updateTransactions err = %s, want nil", err)
+	}
+	return db, ctx, tx, transactions
+}
+
+func searchIDs(ctx context.Context, tx *sql.Tx, t *testing.T, query string, options searchOptions) []string {
+	t.Helper()
+	if options.Limit == 0 {
+		options.Limit = 10
+	}
+	results, err := searchTransactions(ctx, tx, query, options)
+	if err != nil {
+		t.Fatalf("searchTransactions(%q) err = %s, want nil", query, err)
+	}
+	var ids []string
+	for _, r := range results {
+		ids = append(ids, r.ID[:8])
+	}
+	return ids
+}
+
+func TestSearchTransactions(t *testing.T) {
+	db, ctx, tx, _ := prepareSearchDB(t)
+	defer db.Close()
+
+	min, max := 10000, 30000
+	for _, test := range []struct {
+		query   string
+		options searchOptions
+		want    []string
+	}{
+		{"water", searchOptions{}, []string{"295c1843"}},
+		{"bottle*", searchOptions{}, []string{"295c1843"}},
+		{`"for the office"`, searchOptions{}, []string{"295c1843"}},
+		{"payee_name:water", searchOptions{}, nil},
+		// subtransactions are found by their memo and category
+		{"electricity OR electric", searchOptions{}, []string{"dcc9865c"}},
+		{"starting balance", searchOptions{}, []string{"d11bc464", "e4fdb695"}},
+		{"starting NOT visa", searchOptions{}, []string{"e4fdb695"}},
+		{"starting", searchOptions{Min: &min}, []string{"e4fdb695"}},
+		{"checker", searchOptions{Max: &max}, []string{"295c1843", "dcc9865c"}},
+		{"checker", searchOptions{From: "2021-11-25"}, []string{"dcc9865c"}},
+		{"checker", searchOptions{To: "2021-11-24"}, []string{"295c1843", "e4fdb695"}},
+	} {
+		got := searchIDs(ctx, tx, t, test.query, test.options)
+		if len(got) != len(test.want) {
+			t.Fatalf("search %q = %v, want %v", test.query, got, test.want)
+		}
+		found := make(map[string]bool)
+		for _, id := range got {
+			found[id] = true
+		}
+		for _, id := range test.want {
+			if !found[id] {
+				t.Fatalf("search %q = %v, want %v", test.query, got, test.want)
+			}
+		}
+	}
+
+	assertInt(t, "results with limit", len(searchIDs(ctx, tx, t, "checker", searchOptions{Limit: 1})), 1)
+	if _, err := searchTransactions(ctx, tx, `"unbalanced`, searchOptions{Limit: 10}); err == nil {
+		t.Fatalf("searchTransactions with invalid query err = nil, want error")
+	}
+}
+
+func TestUpdateSearchIndex(t *testing.T) {
+	db, ctx, tx, transactions := prepareSearchDB(t)
+	defer db.Close()
+
+	transactions.Data.Transactions[0].Memo = "sparkling"
+	transactions.Data.Transactions[1].Deleted = true
+	transactions.Data.Transactions = transactions.Data.Transactions[:2]
+	if err := updateTransactions(ctx, transactions, tx); err != nil {
+		t.Fatalf("updateTransactions err = %s, want nil", err)
+	}
+	if got := searchIDs(ctx, tx, t, "water", searchOptions{}); len(got) != 1 {
+		t.Fatalf("search water = %v, want the transaction by payee only", got)
+	}
+	if got := searchIDs(ctx, tx, t, "office", searchOptions{}); len(got) != 0 {
+		t.Fatalf("search office = %v, want none after the memo changed", got)
+	}
+	if got := searchIDs(ctx, tx, t, "sparkling", searchOptions{}); len(got) != 1 {
+		t.Fatalf("search sparkling = %v, want the updated transaction", got)
+	}
+	if got := searchIDs(ctx, tx, t, "visa", searchOptions{}); len(got) != 0 {
+		t.Fatalf("search visa = %v, want none after the transaction was deleted", got)
+	}
+
+	mustExec(ctx, tx, t, `UPDATE "transaction" SET memo = 'changed behind our back'`)
+	if err := rebuildSearchIndex(ctx, tx); err != nil {
+		t.Fatalf("rebuildSearchIndex err = %s, want nil", err)
+	}
+	assertInt(t, "indexed transactions", len(searchIDs(ctx, tx, t, "behind", searchOptions{})), 3)
+}
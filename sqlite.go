@@ -4,8 +4,18 @@ import (
 	"context"
 	"database/sql"
 	_ "embed"
+	"fmt"
 )
 
+// openSqlite opens the database file at path. Connections wait up to five
+// seconds for the locks of other connections, e.g. when the metrics are
+// scraped while the daemon commits a sync, instead of failing with
+// SQLITE_BUSY. The driver uses the same timeout by default, it is set here so
+// that it doesn't depend on the driver version.
+func openSqlite(path string) (*sql.DB, error) {
+	return sql.Open("sqlite3", path+"?_busy_timeout=5000")
+}
+
 func NewSqliteService(db *sql.DB) sqliteService {
 	return sqliteService{db: db}
 }
@@ -15,29 +25,24 @@ type sqliteService struct {
 }
 
 func (sql *sqliteService) Transaction(transactionFunction func(context.Context, *sql.Tx) error) error {
-	ctx := context.Background()
-	tx, err := sql.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
-
-	err = transactionFunction(ctx, tx)
-
-	if err != nil {
-		tx.Rollback()
-	} else {
-		tx.Commit()
-	}
+	return sql.TransactionContext(context.Background(), transactionFunction)
+}
 
-	return err
+// TransactionContext runs transactionFunction in a transaction that is rolled
+// back if the function fails or panics, or if ctx is cancelled before the
+// commit.
+func (sql *sqliteService) TransactionContext(ctx context.Context, transactionFunction func(context.Context, *sql.Tx) error) error {
+	return runTransaction(ctx, sql.db, transactionFunction)
 }
 
 //go:embed schema.sql
 var tables string
 
 func (service sqliteService) CreateTables() error {
-	_, err := service.db.Exec(tables)
-	return err
+	if _, err := service.db.Exec(tables); err != nil {
+		return err
+	}
+	return createSearchIndex(service.db)
 }
 
 func loadServerKnowledge(ctx context.Context, tx *sql.Tx) (map[string]int, error) {
@@ -86,7 +91,7 @@ func updateCategories(ctx context.Context, categories Categories, tx *sql.Tx) er
       id, name, hidden, deleted
     ) VALUES(?, ?, ?, ?)
     ON CONFLICT(id) DO UPDATE SET
-      name=excluded.name, hidden=excluded.hidden, deleted=excluded.hidden;
+      name=excluded.name, hidden=excluded.hidden, deleted=excluded.deleted;
   `
 	insertCategorySQL := `
     INSERT INTO category (
@@ -95,18 +100,26 @@ func updateCategories(ctx context.Context, categories Categories, tx *sql.Tx) er
     ) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
     ON CONFLICT(id) DO UPDATE SET
       name=excluded.name, note=excluded.note, category_group_id=excluded.category_group_id,
-      hidden=excluded.name, deleted=excluded.deleted,
+      hidden=excluded.hidden, deleted=excluded.deleted,
       goal_type=excluded.goal_type,
       goal_creation_month=excluded.goal_creation_month,
       goal_target=excluded.goal_target,
       goal_target_month=excluded.goal_target_month;
   `
+	groupChanges, err := newChangeLog(ctx, tx, "category_group", "id")
+	if err != nil {
+		return err
+	}
+	categoryChanges, err := newChangeLog(ctx, tx, "category", "id")
+	if err != nil {
+		return err
+	}
 	for _, group := range categories.Data.CategoryGroups {
 		statement, err := tx.Prepare(insertCategoryGroupSQL)
 		if err != nil {
 			return err
 		}
-		_, err = statement.ExecContext(ctx, group.ID, group.Name, group.Hidden, group.Deleted)
+		err = groupChanges.exec(ctx, statement, group.ID, group.Name, group.Hidden, group.Deleted)
 		if err != nil {
 			return err
 		}
@@ -116,7 +129,7 @@ func updateCategories(ctx context.Context, categories Categories, tx *sql.Tx) er
 			if err != nil {
 				return err
 			}
-			_, err = statement.ExecContext(ctx, category.ID, category.Name, category.Note, category.CategoryGroupID, category.Hidden, category.Deleted, category.GoalType, category.GoalCreationMonth, category.GoalTarget, category.GoalTargetMonth)
+			err = categoryChanges.exec(ctx, statement, category.ID, category.Name, category.Note, category.CategoryGroupID, category.Hidden, category.Deleted, category.GoalType, category.GoalCreationMonth, category.GoalTarget, category.GoalTargetMonth)
 			if err != nil {
 				return err
 			}
@@ -148,23 +161,33 @@ func updateTransactions(ctx context.Context, transactions Transactions, tx *sql.
 		category_name=excluded.category_name;`
 	insertSubtransactionSQL := `
     INSERT INTO "subtransaction" (
-		id, transaction_id, amount, memo, payee_id, category_id,
+		id, transaction_id, amount, memo, payee_id, payee_name, category_id,
 		category_name, transfer_account_id, transfer_transaction_id,
 		deleted
-    ) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    ) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
     ON CONFLICT(id) DO UPDATE SET
 		transaction_id=excluded.transaction_id, amount=excluded.amount, memo=excluded.memo,
-		payee_id=excluded.payee_id, category_id=excluded.category_id,
+		payee_id=excluded.payee_id, payee_name=excluded.payee_name, category_id=excluded.category_id,
 		category_name=excluded.category_name, transfer_account_id=excluded.transfer_account_id,
 		transfer_transaction_id=excluded.transfer_transaction_id, deleted=excluded.deleted;
 	`
 
+	transactionChanges, err := newChangeLog(ctx, tx, "transaction", "id")
+	if err != nil {
+		return err
+	}
+	subtransactionChanges, err := newChangeLog(ctx, tx, "subtransaction", "id")
+	if err != nil {
+		return err
+	}
+	var ids []string
 	for _, t := range transactions.Data.Transactions {
+		ids = append(ids, t.ID)
 		statement, err := tx.Prepare(insertTransactionSQL)
 		if err != nil {
 			return err
 		}
-		_, err = statement.ExecContext(ctx, t.ID, t.Date, t.Amount, t.Memo, t.Cleared, t.Approved,
+		err = transactionChanges.exec(ctx, statement, t.ID, t.Date, t.Amount, t.Memo, t.Cleared, t.Approved,
 			t.FlagColor, t.AccountID, t.PayeeID, t.CategoryID,
 			t.TransferAccountID, t.TransferTransactionID,
 			t.MatchedTransactionID, t.ImportID, t.Deleted,
@@ -175,20 +198,64 @@ func updateTransactions(ctx context.Context, transactions Transactions, tx *sql.
 		for _, st := range t.Subtransactions {
 			statement, err := tx.Prepare(insertSubtransactionSQL)
 			if err != nil {
-				return nil
+				return err
 			}
-			_, err = statement.ExecContext(ctx, st.ID, st.TransactionID, st.Amount, st.Memo,
-				st.PayeeID, st.CategoryID, st.CategoryName, st.TransferAccountID,
+			err = subtransactionChanges.exec(ctx, statement, st.ID, st.TransactionID, st.Amount, st.Memo,
+				st.PayeeID, st.PayeeName, st.CategoryID, st.CategoryName, st.TransferAccountID,
 				st.TransferTransactionID, st.Deleted)
 			if err != nil {
-				return nil
+				return err
 			}
 		}
 	}
+	if err := updateSearchIndex(ctx, tx, ids); err != nil {
+		return err
+	}
+	if err := updateTransactionTags(ctx, tx, ids); err != nil {
+		return fmt.Errorf("could not update tags: %s", err)
+	}
 
 	return updateServerKnowledge(ctx, tx, "transactions", transactions.Data.ServerKnowledge)
 }
 
+func updateScheduledTransactions(ctx context.Context, scheduledTransactions ScheduledTransactions, tx *sql.Tx) error {
+	insertScheduledTransactionSQL := `
+    INSERT INTO scheduled_transaction (
+		id, date_first, date_next, frequency, amount, memo,
+		flag_color, account_id, payee_id, category_id,
+		transfer_account_id, deleted,
+		account_name, payee_name, category_name
+    ) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT(id) DO UPDATE SET
+		date_first=excluded.date_first, date_next=excluded.date_next,
+		frequency=excluded.frequency, amount=excluded.amount, memo=excluded.memo,
+		flag_color=excluded.flag_color, account_id=excluded.account_id,
+		payee_id=excluded.payee_id, category_id=excluded.category_id,
+		transfer_account_id=excluded.transfer_account_id, deleted=excluded.deleted,
+		account_name=excluded.account_name, payee_name=excluded.payee_name,
+		category_name=excluded.category_name;`
+
+	statement, err := tx.Prepare(insertScheduledTransactionSQL)
+	if err != nil {
+		return err
+	}
+	changes, err := newChangeLog(ctx, tx, "scheduled_transaction", "id")
+	if err != nil {
+		return err
+	}
+	for _, t := range scheduledTransactions.Data.ScheduledTransactions {
+		err = changes.exec(ctx, statement, t.ID, t.DateFirst, t.DateNext, t.Frequency, t.Amount, t.Memo,
+			t.FlagColor, t.AccountID, t.PayeeID, t.CategoryID,
+			t.TransferAccountID, t.Deleted,
+			t.AccountName, t.PayeeName, t.CategoryName)
+		if err != nil {
+			return err
+		}
+	}
+
+	return updateServerKnowledge(ctx, tx, "scheduled_transactions", scheduledTransactions.Data.ServerKnowledge)
+}
+
 func updateAccounts(ctx context.Context, accounts Accounts, tx *sql.Tx) error {
 	insertAccountSQL := `
 		INSERT INTO account (
@@ -210,12 +277,16 @@ func updateAccounts(ctx context.Context, accounts Accounts, tx *sql.Tx) error {
 			deleted=excluded.deleted;
 	`
 
+	changes, err := newChangeLog(ctx, tx, "account", "id")
+	if err != nil {
+		return err
+	}
 	for _, account := range accounts.Data.Accounts {
 		statement, err := tx.Prepare(insertAccountSQL)
 		if err != nil {
 			return err
 		}
-		_, err = statement.ExecContext(ctx,
+		err = changes.exec(ctx, statement,
 			account.ID,
 			account.Name,
 			account.Type,
@@ -250,9 +321,13 @@ func updateCategoryMonth(ctx context.Context, categoryMonth CategoryMonth, tx *s
 	if err != nil {
 		return err
 	}
+	changes, err := newChangeLog(ctx, tx, "category_month", "month_id", "category_id")
+	if err != nil {
+		return err
+	}
 
 	for _, category := range categoryMonth.Data.Month.Categories {
-		_, err = statement.ExecContext(ctx,
+		err = changes.exec(ctx, statement,
 			categoryMonth.Data.Month.Month.Month,
 			category.ID,
 			category.Budgeted,
@@ -288,7 +363,11 @@ func updateMonth(ctx context.Context, month Month, tx *sql.Tx) error {
 	if err != nil {
 		return err
 	}
-	_, err = statement.ExecContext(ctx,
+	changes, err := newChangeLog(ctx, tx, "month", "id")
+	if err != nil {
+		return err
+	}
+	return changes.exec(ctx, statement,
 		month.Month,
 		month.Note,
 		month.Income,
@@ -297,7 +376,6 @@ func updateMonth(ctx context.Context, month Month, tx *sql.Tx) error {
 		month.ToBeBudgeted,
 		month.AgeOfMoney,
 		month.Deleted)
-	return err
 }
 
 func updatePayees(ctx context.Context, payees Payees, tx *sql.Tx) error {
@@ -310,12 +388,16 @@ func updatePayees(ctx context.Context, payees Payees, tx *sql.Tx) error {
 		deleted=excluded.deleted
 	;`
 
+	changes, err := newChangeLog(ctx, tx, "payee", "id")
+	if err != nil {
+		return err
+	}
 	for _, payee := range payees.Data.Payees {
 		statement, err := tx.Prepare(insertPayeeSQL)
 		if err != nil {
 			return err
 		}
-		_, err = statement.ExecContext(ctx, payee.ID, payee.Name, payee.TransferAccountID, payee.Deleted)
+		err = changes.exec(ctx, statement, payee.ID, payee.Name, payee.TransferAccountID, payee.Deleted)
 		if err != nil {
 			return err
 		}
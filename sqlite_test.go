@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"os"
 	"reflect"
 	"testing"
@@ -46,6 +47,13 @@ func queryString(ctx context.Context, tx *sql.Tx, query string, t *testing.T) st
 	return got
 }
 
+func mustExec(ctx context.Context, tx *sql.Tx, t *testing.T, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		t.Fatalf("failed to execute %q: %s", query, err)
+	}
+}
+
 func loadFixture(path string, typ interface{}, t *testing.T) {
 	t.Helper()
 	content, err := os.ReadFile(path)
@@ -58,7 +66,7 @@ func loadFixture(path string, typ interface{}, t *testing.T) {
 func TestCreateTables(t *testing.T) {
 	db := prepareDB(t)
 	defer db.Close()
-	res, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name")
+	res, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'transaction_search%' ORDER BY name")
 	if err != nil {
 		t.Fatalf("failed to query database %s", err)
 	}
@@ -75,8 +83,10 @@ func TestCreateTables(t *testing.T) {
 	if err := res.Err(); err != nil {
 		t.Fatalf("failed to query database %s", err)
 	}
-	want := []string{"account", "category", "category_group", "category_month",
-		"month", "payee", "server_knowledge", "subtransaction", "transaction"}
+	want := []string{"account", "age_of_money_daily", "category", "category_group", "category_month",
+		"change_log", "heartbeat", "month", "payee", "recurring_series", "rule", "scheduled_transaction",
+		"server_knowledge", "subtransaction", "sync_run", "tag", "transaction", "transaction_audit",
+		"transaction_tag"}
 	if !reflect.DeepEqual(want, tables) {
 		t.Fatalf("%v != %v", want, tables)
 	}
@@ -90,11 +100,12 @@ func TestLoadServerKnowledge(t *testing.T) {
 		t.Fatalf("loadServerKnowledge err = %s, want nil", err)
 	}
 	want := map[string]int{
-		"accounts":     0,
-		"categories":   0,
-		"months":       0,
-		"payees":       0,
-		"transactions": 0,
+		"accounts":               0,
+		"categories":             0,
+		"months":                 0,
+		"payees":                 0,
+		"transactions":           0,
+		"scheduled_transactions": 0,
 	}
 	if !reflect.DeepEqual(res, want) {
 		t.Fatalf("loadServerKnowledge = %v, want %v", res, want)
@@ -131,6 +142,22 @@ func TestUpdateCategories(t *testing.T) {
 	if want := "Internal Master Category"; got != want {
 		t.Fatalf("%q != %q", want, got)
 	}
+
+	// updating existing categories keeps the flags
+
+	categories.Data.CategoryGroups[0].Hidden = true
+	categories.Data.CategoryGroups[0].Categories[0].Hidden = true
+	if err := updateCategories(ctx, categories, tx); err != nil {
+		t.Fatalf("updateCategories err = %s, want nil", err)
+	}
+	got = queryString(ctx, tx, "SELECT hidden || deleted FROM category_group WHERE id = 'e8e9fa0e-0667-4b8f-afb8-8f0c0a151a1d'", t)
+	if want := "10"; got != want {
+		t.Fatalf("%q != %q", want, got)
+	}
+	got = queryString(ctx, tx, "SELECT hidden || deleted FROM category WHERE id = '38c7f79a-97f5-4e54-aa8f-7da18a426bf0'", t)
+	if want := "10"; got != want {
+		t.Fatalf("%q != %q", want, got)
+	}
 }
 
 func TestUpdateTransactions(t *testing.T) {
@@ -180,13 +207,14 @@ func TestUpdateTransactionsSubtransactions(t *testing.T) {
 	for _, transaction := range transactions.Data.Transactions {
 		if transaction.ID == "dcc9865c-dd45-468b-93c3-fa6b327db3fe_2021-11-25" {
 			transaction.Subtransactions[0].CategoryName = "Whatever"
+			transaction.Subtransactions[0].PayeeName = "Landlord"
 		}
 	}
 	if err := updateTransactions(ctx, transactions, tx); err != nil {
 		t.Fatalf("updateTransactions err = %s, want nil", err)
 	}
-	got = queryString(ctx, tx, `SELECT category_name FROM "subtransaction" WHERE id = "9e53be73-3f80-4047-aacf-ca975a1b430e"`, t)
-	if want := "Whatever"; got != want {
+	got = queryString(ctx, tx, `SELECT category_name || '/' || payee_name FROM "subtransaction" WHERE id = "9e53be73-3f80-4047-aacf-ca975a1b430e"`, t)
+	if want := "Whatever/Landlord"; got != want {
 		t.Fatalf("%q != %q", want, got)
 	}
 }
@@ -297,3 +325,87 @@ func TestUpdatePayees(t *testing.T) {
 		t.Fatalf("%v != %v", got, want)
 	}
 }
+
+func TestUpdateScheduledTransactions(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	var scheduledTransactions ScheduledTransactions
+	loadFixture("./fixtures/scheduled_transactions.json", &scheduledTransactions, t)
+
+	if err := updateScheduledTransactions(ctx, scheduledTransactions, tx); err != nil {
+		t.Fatalf("updateScheduledTransactions err = %s, want nil", err)
+	}
+	got := queryString(ctx, tx, `SELECT frequency FROM scheduled_transaction WHERE id = "0c5d5f1a-0d6b-4a4e-9b2b-4f4cc5e2c8a1"`, t)
+	if want := "monthly"; got != want {
+		t.Fatalf("%q != %q", got, want)
+	}
+
+	scheduledTransactions.Data.ScheduledTransactions[0].DateNext = "2022-01-01"
+	if err := updateScheduledTransactions(ctx, scheduledTransactions, tx); err != nil {
+		t.Fatalf("updateScheduledTransactions err = %s, want nil", err)
+	}
+	got = queryString(ctx, tx, `SELECT date_next FROM scheduled_transaction WHERE id = "0c5d5f1a-0d6b-4a4e-9b2b-4f4cc5e2c8a1"`, t)
+	if want := "2022-01-01"; got != want {
+		t.Fatalf("%q != %q", got, want)
+	}
+	got = queryString(ctx, tx, `SELECT value FROM server_knowledge WHERE endpoint = "scheduled_transactions"`, t)
+	if want := "99"; got != want {
+		t.Fatalf("%q != %q", got, want)
+	}
+}
+
+func TestLedger(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	mustExec(ctx, tx, t, `INSERT INTO account (id, name, on_budget, deleted) VALUES
+		('checking', 'Checking', 1, 0), ('savings', 'Savings', 0, 0), ('gone', 'Gone', 1, 1)`)
+	mustExec(ctx, tx, t, `INSERT INTO payee (id, name, deleted) VALUES ('market', 'Market', 0), ('bakery', 'Bakery', 0)`)
+	mustExec(ctx, tx, t, `INSERT INTO category_group (id, name, hidden, deleted) VALUES ('food', 'Food', 0, 0)`)
+	mustExec(ctx, tx, t, `INSERT INTO category (id, category_group_id, name, hidden, deleted) VALUES
+		('groceries', 'food', 'Groceries', 0, 0), ('sweets', 'food', 'Sweets', 0, 0)`)
+	mustExec(ctx, tx, t, `INSERT INTO "transaction" (id, date, amount, memo, account_id, payee_id, payee_name, category_id, category_name, transfer_account_id, deleted) VALUES
+		('plain', '2022-01-01', -12340, 'milk', 'checking', 'market', 'Old market name', 'groceries', 'Groceries', NULL, 0),
+		('split', '2022-01-02', -30000, 'weekend', 'checking', 'market', 'Market', NULL, 'Split', NULL, 0),
+		('transfer', '2022-01-03', -50000, '', 'checking', NULL, 'Transfer : Savings', NULL, NULL, 'savings', 0),
+		('deleted', '2022-01-04', -1000, '', 'checking', NULL, NULL, NULL, NULL, NULL, 1),
+		('gone', '2022-01-05', -1000, '', 'gone', NULL, NULL, NULL, NULL, NULL, 0)`)
+	mustExec(ctx, tx, t, `INSERT INTO subtransaction (id, transaction_id, amount, memo, payee_id, category_id, category_name, deleted) VALUES
+		('split-1', 'split', -20000, '', '', 'groceries', 'Groceries', 0),
+		('split-2', 'split', -10000, 'cake', 'bakery', 'sweets', 'Sweets', 0),
+		('split-3', 'split', -5000, 'removed', '', 'sweets', 'Sweets', 1)`)
+
+	res, err := tx.QueryContext(ctx, `
+		SELECT transaction_id || IFNULL('/' || subtransaction_id, ''), account_name, on_budget,
+			IFNULL(payee_name, ''), IFNULL(category_name, ''), IFNULL(category_group_name, ''),
+			IFNULL(memo, ''), amount, decimal_amount, transfer, IFNULL(transfer_account_name, '')
+		FROM ledger
+		ORDER BY date, subtransaction_id`)
+	if err != nil {
+		t.Fatalf("failed to query ledger: %s", err)
+	}
+	defer res.Close()
+	var got []string
+	for res.Next() {
+		var id, account, payee, category, group, memo, transferAccount string
+		var onBudget, transfer bool
+		var amount int
+		var decimalAmount float64
+		if err := res.Scan(&id, &account, &onBudget, &payee, &category, &group, &memo,
+			&amount, &decimalAmount, &transfer, &transferAccount); err != nil {
+			t.Fatalf("failed to scan ledger: %s", err)
+		}
+		got = append(got, fmt.Sprintf("%s %s %t %s %s %s %s %d %.2f %t %s",
+			id, account, onBudget, payee, category, group, memo, amount, decimalAmount, transfer, transferAccount))
+	}
+	want := []string{
+		"plain Checking true Market Groceries Food milk -12340 -12.34 false ",
+		"split/split-1 Checking true Market Groceries Food weekend -20000 -20.00 false ",
+		"split/split-2 Checking true Bakery Sweets Food cake -10000 -10.00 false ",
+		"transfer Checking true Transfer : Savings    -50000 -50.00 true Savings",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ledger = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// statementLine is a single booking read from a bank statement file
+type statementLine struct {
+	Date   string // YYYY-MM-DD
+	Amount int    // milliunits, negative for outflows
+	Payee  string
+	Memo   string
+}
+
+// csvMapping describes which columns of a bank CSV export contain which value.
+// Columns are referenced by their header name. Banks that export inflow and
+// outflow into separate columns can use Inflow and Outflow instead of Amount.
+type csvMapping struct {
+	Date             string
+	Amount           string
+	Inflow           string
+	Outflow          string
+	Payee            string
+	Memo             string
+	DateFormat       string // Go reference layout, e.g. 02.01.2006
+	Delimiter        rune
+	DecimalSeparator string
+	Skip             int // lines before the header row
+}
+
+func defaultCSVMapping() csvMapping {
+	return csvMapping{
+		Date:             "Date",
+		Amount:           "Amount",
+		Payee:            "Payee",
+		Memo:             "Memo",
+		DateFormat:       "2006-01-02",
+		Delimiter:        ',',
+		DecimalSeparator: ".",
+	}
+}
+
+// csvMappingFlags adds the flags that describe the layout of a CSV statement.
+// The mapping is returned once the flags are parsed.
+func csvMappingFlags(flags *flag.FlagSet) func() csvMapping {
+	mapping := defaultCSVMapping()
+	flags.StringVar(&mapping.Date, "date-column", mapping.Date, "csv column containing the booking date")
+	flags.StringVar(&mapping.Amount, "amount-column", mapping.Amount, "csv column containing the amount, leave empty when using inflow/outflow columns")
+	flags.StringVar(&mapping.Inflow, "inflow-column", mapping.Inflow, "csv column containing inflows")
+	flags.StringVar(&mapping.Outflow, "outflow-column", mapping.Outflow, "csv column containing outflows")
+	flags.StringVar(&mapping.Payee, "payee-column", mapping.Payee, "csv column containing the payee")
+	flags.StringVar(&mapping.Memo, "memo-column", mapping.Memo, "csv column containing the memo")
+	flags.StringVar(&mapping.DateFormat, "date-format", mapping.DateFormat, "layout of csv dates in Go reference time notation")
+	flags.StringVar(&mapping.DecimalSeparator, "decimal-separator", mapping.DecimalSeparator, "decimal separator of csv amounts")
+	flags.IntVar(&mapping.Skip, "skip", mapping.Skip, "number of lines before the csv header")
+	delimiter := flags.String("delimiter", string(mapping.Delimiter), "csv field delimiter")
+	return func() csvMapping {
+		mapping.Delimiter, _ = utf8.DecodeRuneInString(*delimiter)
+		return mapping
+	}
+}
+
+// readStatementFile reads a CSV or OFX statement, depending on the file
+// extension.
+func readStatementFile(path string, mapping csvMapping) ([]statementLine, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ofx", ".qfx":
+		return readOFXStatement(file)
+	default:
+		return readCSVStatement(file, mapping)
+	}
+}
+
+// readStatementBalance returns the closing balance of an OFX statement and
+// the date it refers to. CSV statements have no balance, ok is false then.
+func readStatementBalance(path string) (balance int, date string, ok bool, err error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ofx", ".qfx":
+	default:
+		return 0, "", false, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", false, err
+	}
+	defer file.Close()
+	return readOFXBalance(file)
+}
+
+func readCSVStatement(r io.Reader, mapping csvMapping) ([]statementLine, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = mapping.Delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	for i := 0; i < mapping.Skip; i++ {
+		if _, err := reader.Read(); err != nil {
+			return nil, err
+		}
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read csv header: %s", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+
+	column := func(record []string, name string) (string, error) {
+		if name == "" {
+			return "", nil
+		}
+		i, ok := columns[name]
+		if !ok {
+			return "", fmt.Errorf("column %q not found in csv header", name)
+		}
+		if i >= len(record) {
+			return "", nil
+		}
+		return strings.TrimSpace(record[i]), nil
+	}
+
+	var lines []statementLine
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var line statementLine
+		rawDate, err := column(record, mapping.Date)
+		if err != nil {
+			return nil, err
+		}
+		date, err := time.Parse(mapping.DateFormat, rawDate)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse date %q: %s", rawDate, err)
+		}
+		line.Date = date.Format("2006-01-02")
+
+		row, _ := reader.FieldPos(0)
+		if mapping.Amount != "" {
+			rawAmount, err := column(record, mapping.Amount)
+			if err != nil {
+				return nil, err
+			}
+			if line.Amount, err = parseAmount(rawAmount, mapping.DecimalSeparator); err != nil {
+				return nil, fmt.Errorf("line %d: %s", row, err)
+			}
+		} else {
+			rawInflow, err := column(record, mapping.Inflow)
+			if err != nil {
+				return nil, err
+			}
+			rawOutflow, err := column(record, mapping.Outflow)
+			if err != nil {
+				return nil, err
+			}
+			// one of the columns is empty
+			if rawInflow == "" && rawOutflow == "" {
+				return nil, fmt.Errorf("line %d: neither inflow nor outflow", row)
+			}
+			var inflow, outflow int
+			if rawInflow != "" {
+				if inflow, err = parseAmount(rawInflow, mapping.DecimalSeparator); err != nil {
+					return nil, fmt.Errorf("line %d: %s", row, err)
+				}
+			}
+			if rawOutflow != "" {
+				if outflow, err = parseAmount(rawOutflow, mapping.DecimalSeparator); err != nil {
+					return nil, fmt.Errorf("line %d: %s", row, err)
+				}
+			}
+			line.Amount = abs(inflow) - abs(outflow)
+		}
+
+		if line.Payee, err = column(record, mapping.Payee); err != nil {
+			return nil, err
+		}
+		if line.Memo, err = column(record, mapping.Memo); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// readOFXStatement reads the STMTTRN elements of an OFX file. It understands
+// the SGML based OFX 1.x format, where leaf elements aren't closed, as well as
+// the XML based OFX 2.x format.
+func readOFXStatement(r io.Reader) ([]statementLine, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []statementLine
+	var current *statementLine
+	for _, element := range strings.Split(string(content), "<")[1:] {
+		tag, value, _ := strings.Cut(element, ">")
+		value = html.UnescapeString(strings.TrimSpace(value))
+		tag = strings.ToUpper(tag)
+
+		if tag == "STMTTRN" {
+			current = &statementLine{}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		switch tag {
+		case "/STMTTRN":
+			lines = append(lines, *current)
+			current = nil
+		case "DTPOSTED":
+			if len(value) < 8 {
+				return nil, fmt.Errorf("could not parse date %q", value)
+			}
+			date, err := time.Parse("20060102", value[:8])
+			if err != nil {
+				return nil, fmt.Errorf("could not parse date %q: %s", value, err)
+			}
+			current.Date = date.Format("2006-01-02")
+		case "TRNAMT":
+			if current.Amount, err = parseAmount(value, "."); err != nil {
+				return nil, err
+			}
+		case "NAME", "PAYEE":
+			current.Payee = value
+		case "MEMO":
+			current.Memo = value
+		}
+	}
+	return lines, nil
+}
+
+// readOFXBalance reads the BALAMT and DTASOF of the LEDGERBAL element, the
+// booked balance at the end of the statement.
+func readOFXBalance(r io.Reader) (balance int, date string, ok bool, err error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return 0, "", false, err
+	}
+
+	inLedger := false
+	for _, element := range strings.Split(string(content), "<")[1:] {
+		tag, value, _ := strings.Cut(element, ">")
+		value = strings.TrimSpace(value)
+
+		switch strings.ToUpper(tag) {
+		case "LEDGERBAL":
+			inLedger = true
+		case "/LEDGERBAL":
+			inLedger = false
+		case "BALAMT":
+			if !inLedger {
+				continue
+			}
+			if balance, err = parseAmount(value, "."); err != nil {
+				return 0, "", false, err
+			}
+			ok = true
+		case "DTASOF":
+			if inLedger && len(value) >= 8 {
+				if parsed, err := time.Parse("20060102", value[:8]); err == nil {
+					date = parsed.Format("2006-01-02")
+				}
+			}
+		}
+	}
+	return balance, date, ok, nil
+}
+
+// parseAmount converts a decimal amount like "-1.234,56" into milliunits.
+// Everything except digits, the sign and the decimal separator is ignored,
+// which removes thousands separators and currency symbols.
+func parseAmount(value string, decimalSeparator string) (int, error) {
+	var cleaned strings.Builder
+	negative := false
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			cleaned.WriteRune(r)
+		case r == '-':
+			negative = true
+		case string(r) == decimalSeparator:
+			cleaned.WriteRune('.')
+		}
+	}
+	if cleaned.Len() == 0 {
+		return 0, fmt.Errorf("could not parse amount %q: no digits", value)
+	}
+
+	whole, fraction, _ := strings.Cut(cleaned.String(), ".")
+	if len(fraction) > 3 {
+		return 0, fmt.Errorf("could not parse amount %q: more than three decimal places", value)
+	}
+	fraction += strings.Repeat("0", 3-len(fraction))
+	if whole == "" {
+		whole = "0"
+	}
+	amount, err := strconv.Atoi(whole + fraction)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse amount %q: %s", value, err)
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
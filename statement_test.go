@@ -0,0 +1,123 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		value            string
+		decimalSeparator string
+		want             int
+	}{
+		{"-23.00", ".", -23000},
+		{"1,234.5", ".", 1234500},
+		{"-1.234,56 €", ",", -1234560},
+		{"0.001", ".", 1},
+	}
+	for _, test := range tests {
+		got, err := parseAmount(test.value, test.decimalSeparator)
+		if err != nil {
+			t.Fatalf("parseAmount(%q) err = %s, want nil", test.value, err)
+		}
+		if got != test.want {
+			t.Fatalf("parseAmount(%q) = %d, want %d", test.value, got, test.want)
+		}
+	}
+
+	for _, value := range []string{"1.2345", "", "-", "n/a"} {
+		if _, err := parseAmount(value, "."); err == nil {
+			t.Fatalf("parseAmount(%q) err = nil, want error", value)
+		}
+	}
+}
+
+func TestReadCSVStatement(t *testing.T) {
+	mapping := csvMapping{
+		Date:             "Buchungstag",
+		Inflow:           "Haben",
+		Outflow:          "Soll",
+		Payee:            "Empfänger",
+		Memo:             "Verwendungszweck",
+		DateFormat:       "02.01.2006",
+		Delimiter:        ';',
+		DecimalSeparator: ",",
+		Skip:             1,
+	}
+	lines, err := readStatementFile("./fixtures/statement.csv", mapping)
+	if err != nil {
+		t.Fatalf("readStatementFile err = %s, want nil", err)
+	}
+	want := []statementLine{
+		{Date: "2021-11-24", Amount: -23000, Payee: "Hugo", Memo: "Water bill"},
+		{Date: "2021-11-25", Amount: 1200500, Payee: "Employer GmbH", Memo: "Salary"},
+		{Date: "2021-11-25", Amount: -23000, Payee: "Hugo", Memo: "Water bill"},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("readStatementFile = %v, want %v", lines, want)
+	}
+}
+
+func TestReadStatementBalance(t *testing.T) {
+	balance, date, ok, err := readStatementBalance("./fixtures/statement.ofx")
+	if err != nil {
+		t.Fatalf("readStatementBalance err = %s, want nil", err)
+	}
+	assertValue(t, "ok", ok, true)
+	assertInt(t, "balance", balance, 1523450)
+	assertValue(t, "date", date, "2021-11-30")
+
+	// the available balance is not the ledger balance
+	balance, _, ok, err = readOFXBalance(strings.NewReader("<AVAILBAL><BALAMT>10.00<DTASOF>20211130</AVAILBAL>"))
+	if err != nil || ok {
+		t.Fatalf("readOFXBalance = %d, %v, %v, want no balance", balance, ok, err)
+	}
+
+	_, _, ok, err = readStatementBalance("./fixtures/statement.csv")
+	if err != nil || ok {
+		t.Fatalf("readStatementBalance(csv) = %v, %v, want no balance", ok, err)
+	}
+}
+
+func TestReadCSVStatementEmptyAmount(t *testing.T) {
+	inflowOutflow := defaultCSVMapping()
+	inflowOutflow.Amount, inflowOutflow.Inflow, inflowOutflow.Outflow = "", "Inflow", "Outflow"
+	for _, test := range []struct {
+		content string
+		mapping csvMapping
+		want    string
+	}{
+		{"Date,Payee,Memo,Amount\n2021-11-24,Hugo,,-23.00\n2021-11-25,Hugo,,\n", defaultCSVMapping(), `line 3: could not parse amount "": no digits`},
+		{"Date,Payee,Memo,Inflow,Outflow\n2021-11-25,Hugo,,,\n", inflowOutflow, "line 2: neither inflow nor outflow"},
+	} {
+		_, err := readCSVStatement(strings.NewReader(test.content), test.mapping)
+		if err == nil || err.Error() != test.want {
+			t.Fatalf("readCSVStatement err = %v, want %q", err, test.want)
+		}
+	}
+}
+
+func TestReadCSVStatementUnknownColumn(t *testing.T) {
+	mapping := defaultCSVMapping()
+	mapping.Delimiter = ';'
+	mapping.Skip = 1
+	if _, err := readStatementFile("./fixtures/statement.csv", mapping); err == nil {
+		t.Fatal("readStatementFile err = nil, want error")
+	}
+}
+
+func TestReadOFXStatement(t *testing.T) {
+	lines, err := readStatementFile("./fixtures/statement.ofx", defaultCSVMapping())
+	if err != nil {
+		t.Fatalf("readStatementFile err = %s, want nil", err)
+	}
+	want := []statementLine{
+		{Date: "2021-11-24", Amount: -23000, Payee: "Hugo", Memo: "Water & sewage"},
+		{Date: "2021-11-25", Amount: 1200500, Payee: "Employer"},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("readStatementFile = %v, want %v", lines, want)
+	}
+}
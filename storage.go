@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+)
+
+// storage is where syncs are stored. The local SQLite database is one,
+// PostgreSQL another.
+type storage interface {
+	// Sync runs fn in a transaction. Nothing is stored if fn fails or ctx
+	// is cancelled before fn returns.
+	Sync(ctx context.Context, fn func(context.Context, storageTx) error) error
+	// Close closes the database
+	Close() error
+}
+
+// storageTx are the reads and writes of a sync
+type storageTx interface {
+	LoadServerKnowledge(ctx context.Context) (map[string]int, error)
+	// ChangeEvents compares the responses with what is stored
+	ChangeEvents(ctx context.Context, responses Responses) ([]changeEvent, error)
+	StartSyncRun(ctx context.Context) (int64, error)
+	FinishSyncRun(ctx context.Context, id int64) error
+
+	UpdateCategories(ctx context.Context, categories Categories) error
+	UpdateMonthServerKnowledge(ctx context.Context, months Months) error
+	UpdateAccounts(ctx context.Context, accounts Accounts) error
+	UpdateTransactions(ctx context.Context, transactions Transactions) error
+	UpdateScheduledTransactions(ctx context.Context, scheduledTransactions ScheduledTransactions) error
+	UpdateMonth(ctx context.Context, month Month) error
+	UpdateCategoryMonth(ctx context.Context, categoryMonth CategoryMonth) error
+	UpdatePayees(ctx context.Context, payees Payees) error
+}
+
+// storageFromEnv returns the SQLite database mirrored into the PostgreSQL
+// database of POSTGRES_URL, just the SQLite database if it isn't set
+func storageFromEnv(sqlite sqliteService) (storage, error) {
+	if url, ok := os.LookupEnv("POSTGRES_URL"); ok && url != "" {
+		postgres, err := newPostgresStorage(url)
+		if err != nil {
+			return nil, err
+		}
+		return mirrorStorage{sqlite: sqlite, mirror: postgres}, nil
+	}
+	return sqlite, nil
+}
+
+// mirrorStorage stores every sync in the SQLite database and in mirror. All
+// commands read the SQLite database, which stays up to date that way.
+type mirrorStorage struct {
+	sqlite sqliteService
+	mirror storage
+}
+
+// Sync runs fn once in a transaction of both databases. The SQLite one is
+// committed first. If the commit of mirror fails, its older server knowledge
+// makes the next sync load the missing changes again.
+func (m mirrorStorage) Sync(ctx context.Context, fn func(context.Context, storageTx) error) error {
+	return m.mirror.Sync(ctx, func(ctx context.Context, mirror storageTx) error {
+		return m.sqlite.Sync(ctx, func(ctx context.Context, sqlite storageTx) error {
+			return fn(ctx, &mirrorTx{sqlite: sqlite, mirror: mirror})
+		})
+	})
+}
+
+// Close closes mirror, the SQLite database is closed by its owner
+func (m mirrorStorage) Close() error {
+	return m.mirror.Close()
+}
+
+// mirrorTx writes to both transactions. Change events are computed with the
+// SQLite one.
+type mirrorTx struct {
+	sqlite    storageTx
+	mirror    storageTx
+	mirrorRun int64
+}
+
+// LoadServerKnowledge returns the older knowledge of both databases for
+// every endpoint, the upserts of the changes one of them has seen already
+// don't change anything.
+func (m *mirrorTx) LoadServerKnowledge(ctx context.Context) (map[string]int, error) {
+	serverKnowledge, err := m.sqlite.LoadServerKnowledge(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mirrored, err := m.mirror.LoadServerKnowledge(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for endpoint, value := range serverKnowledge {
+		if mirrored[endpoint] < value {
+			serverKnowledge[endpoint] = mirrored[endpoint]
+		}
+	}
+	return serverKnowledge, nil
+}
+
+func (m *mirrorTx) ChangeEvents(ctx context.Context, responses Responses) ([]changeEvent, error) {
+	return m.sqlite.ChangeEvents(ctx, responses)
+}
+
+func (m *mirrorTx) StartSyncRun(ctx context.Context) (int64, error) {
+	var err error
+	if m.mirrorRun, err = m.mirror.StartSyncRun(ctx); err != nil {
+		return 0, err
+	}
+	return m.sqlite.StartSyncRun(ctx)
+}
+
+func (m *mirrorTx) FinishSyncRun(ctx context.Context, id int64) error {
+	if err := m.mirror.FinishSyncRun(ctx, m.mirrorRun); err != nil {
+		return err
+	}
+	return m.sqlite.FinishSyncRun(ctx, id)
+}
+
+func (m *mirrorTx) UpdateCategories(ctx context.Context, categories Categories) error {
+	if err := m.sqlite.UpdateCategories(ctx, categories); err != nil {
+		return err
+	}
+	return m.mirror.UpdateCategories(ctx, categories)
+}
+
+func (m *mirrorTx) UpdateMonthServerKnowledge(ctx context.Context, months Months) error {
+	if err := m.sqlite.UpdateMonthServerKnowledge(ctx, months); err != nil {
+		return err
+	}
+	return m.mirror.UpdateMonthServerKnowledge(ctx, months)
+}
+
+func (m *mirrorTx) UpdateAccounts(ctx context.Context, accounts Accounts) error {
+	if err := m.sqlite.UpdateAccounts(ctx, accounts); err != nil {
+		return err
+	}
+	return m.mirror.UpdateAccounts(ctx, accounts)
+}
+
+func (m *mirrorTx) UpdateTransactions(ctx context.Context, transactions Transactions) error {
+	if err := m.sqlite.UpdateTransactions(ctx, transactions); err != nil {
+		return err
+	}
+	return m.mirror.UpdateTransactions(ctx, transactions)
+}
+
+func (m *mirrorTx) UpdateScheduledTransactions(ctx context.Context, scheduledTransactions ScheduledTransactions) error {
+	if err := m.sqlite.UpdateScheduledTransactions(ctx, scheduledTransactions); err != nil {
+		return err
+	}
+	return m.mirror.UpdateScheduledTransactions(ctx, scheduledTransactions)
+}
+
+func (m *mirrorTx) UpdateMonth(ctx context.Context, month Month) error {
+	if err := m.sqlite.UpdateMonth(ctx, month); err != nil {
+		return err
+	}
+	return m.mirror.UpdateMonth(ctx, month)
+}
+
+func (m *mirrorTx) UpdateCategoryMonth(ctx context.Context, categoryMonth CategoryMonth) error {
+	if err := m.sqlite.UpdateCategoryMonth(ctx, categoryMonth); err != nil {
+		return err
+	}
+	return m.mirror.UpdateCategoryMonth(ctx, categoryMonth)
+}
+
+func (m *mirrorTx) UpdatePayees(ctx context.Context, payees Payees) error {
+	if err := m.sqlite.UpdatePayees(ctx, payees); err != nil {
+		return err
+	}
+	return m.mirror.UpdatePayees(ctx, payees)
+}
+
+// runTransaction runs fn in a transaction that is rolled back if fn fails or
+// panics, or if ctx is cancelled before the commit.
+func runTransaction(ctx context.Context, db *sql.DB, fn func(context.Context, *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (service sqliteService) Close() error {
+	return service.db.Close()
+}
+
+func (service sqliteService) Sync(ctx context.Context, fn func(context.Context, storageTx) error) error {
+	return service.TransactionContext(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return fn(ctx, sqliteTx{tx})
+	})
+}
+
+// sqliteTx stores a sync with the writers of sqlite.go
+type sqliteTx struct {
+	tx *sql.Tx
+}
+
+func (s sqliteTx) LoadServerKnowledge(ctx context.Context) (map[string]int, error) {
+	return loadServerKnowledge(ctx, s.tx)
+}
+
+func (s sqliteTx) ChangeEvents(ctx context.Context, responses Responses) ([]changeEvent, error) {
+	return changeEvents(ctx, s.tx.QueryRowContext, responses)
+}
+
+func (s sqliteTx) StartSyncRun(ctx context.Context) (int64, error) {
+	return startSyncRun(ctx, s.tx)
+}
+
+func (s sqliteTx) FinishSyncRun(ctx context.Context, id int64) error {
+	return finishSyncRun(ctx, s.tx, id)
+}
+
+func (s sqliteTx) UpdateCategories(ctx context.Context, categories Categories) error {
+	return updateCategories(ctx, categories, s.tx)
+}
+
+func (s sqliteTx) UpdateMonthServerKnowledge(ctx context.Context, months Months) error {
+	return updateMonthServerKnowledge(ctx, months, s.tx)
+}
+
+func (s sqliteTx) UpdateAccounts(ctx context.Context, accounts Accounts) error {
+	return updateAccounts(ctx, accounts, s.tx)
+}
+
+func (s sqliteTx) UpdateTransactions(ctx context.Context, transactions Transactions) error {
+	return updateTransactions(ctx, transactions, s.tx)
+}
+
+func (s sqliteTx) UpdateScheduledTransactions(ctx context.Context, scheduledTransactions ScheduledTransactions) error {
+	return updateScheduledTransactions(ctx, scheduledTransactions, s.tx)
+}
+
+func (s sqliteTx) UpdateMonth(ctx context.Context, month Month) error {
+	return updateMonth(ctx, month, s.tx)
+}
+
+func (s sqliteTx) UpdateCategoryMonth(ctx context.Context, categoryMonth CategoryMonth) error {
+	return updateCategoryMonth(ctx, categoryMonth, s.tx)
+}
+
+func (s sqliteTx) UpdatePayees(ctx context.Context, payees Payees) error {
+	return updatePayees(ctx, payees, s.tx)
+}
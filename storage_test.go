@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fixtureServer answers the requests of a sync with the fixtures
+func fixtureServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/budgets/last-used/")
+		fixture := map[string]string{
+			"categories":             "categories.json",
+			"months":                 "month.json",
+			"accounts":               "accounts.json",
+			"transactions":           "transactions.json",
+			"payees":                 "payees.json",
+			"scheduled_transactions": "scheduled_transactions.json",
+		}[path]
+		month := strings.TrimPrefix(path, "months/")
+		if month != path {
+			fixture = "category-month.json"
+		}
+		content, err := os.ReadFile("./fixtures/" + fixture)
+		if fixture == "" || err != nil {
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// the budget of every month is the one of the fixture
+		w.Write([]byte(strings.Replace(string(content), `"2022-12-01"`, `"`+month+`"`, 1)))
+	}))
+}
+
+// testStorageSync syncs the fixtures into store twice and compares the row
+// counts of every db with those of the responses
+func testStorageSync(t *testing.T, store storage, dbs ...*sql.DB) {
+	ts := fixtureServer(t)
+	defer ts.Close()
+	ynab := NewYNAB(ts.URL, "token", "last-used")
+
+	result, err := syncBudgetContext(context.Background(), ynab, store)
+	if err != nil {
+		t.Fatalf("syncBudgetContext err = %s, want nil", err)
+	}
+	// the history of the first sync isn't reported as changes
+	if len(result.Events) != 0 {
+		t.Fatalf("Events = %v, want none on the first sync", result.Events)
+	}
+	// syncing the same responses again updates the rows
+	result, err = syncBudgetContext(context.Background(), ynab, store)
+	if err != nil {
+		t.Fatalf("second syncBudgetContext err = %s, want nil", err)
+	}
+	if len(result.Events) == 0 {
+		t.Fatalf("Events = %v, want the updated transactions", result.Events)
+	}
+	for _, event := range result.Events {
+		if event.Type != "transaction.updated" {
+			t.Fatalf("second sync event %v, want only updated transactions", event)
+		}
+	}
+
+	for _, db := range dbs {
+		assertStoredSync(t, db, result.Rows)
+	}
+}
+
+// assertStoredSync compares the row counts of db with rows and checks that
+// the two syncs of testStorageSync were recorded
+func assertStoredSync(t *testing.T, db *sql.DB, rows map[string]int) {
+	t.Helper()
+	for table, want := range rows {
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM "` + table + `"`).Scan(&count); err != nil {
+			t.Fatalf("failed to count %s: %s", table, err)
+		}
+		assertInt(t, table, count, want)
+	}
+	var runs int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sync_run WHERE finished_at IS NOT NULL").Scan(&runs); err != nil {
+		t.Fatalf("failed to count sync runs: %s", err)
+	}
+	assertInt(t, "sync runs", runs, 2)
+	var knowledge int
+	if err := db.QueryRow("SELECT value FROM server_knowledge WHERE endpoint = 'transactions'").Scan(&knowledge); err != nil {
+		t.Fatalf("failed to query server knowledge: %s", err)
+	}
+	if knowledge == 0 {
+		t.Fatalf("server knowledge of transactions = 0, want the one of the response")
+	}
+}
+
+func TestSqliteStorageSync(t *testing.T) {
+	db, sqlite := prepareFileDB(t)
+	defer db.Close()
+	testStorageSync(t, sqlite, db)
+}
+
+func TestMirrorStorageSync(t *testing.T) {
+	db, sqlite := prepareFileDB(t)
+	defer db.Close()
+	mirrorDB, mirror := prepareFileDB(t)
+	defer mirrorDB.Close()
+	testStorageSync(t, mirrorStorage{sqlite: sqlite, mirror: mirror}, db, mirrorDB)
+}
+
+func TestMirrorServerKnowledge(t *testing.T) {
+	db, sqlite := prepareFileDB(t)
+	defer db.Close()
+	mirrorDB, mirror := prepareFileDB(t)
+	defer mirrorDB.Close()
+
+	if _, err := db.Exec(`UPDATE server_knowledge SET value = CASE endpoint WHEN 'transactions' THEN 20 WHEN 'payees' THEN 5 ELSE value END`); err != nil {
+		t.Fatalf("failed to update server knowledge: %s", err)
+	}
+	if _, err := mirrorDB.Exec(`UPDATE server_knowledge SET value = CASE endpoint WHEN 'transactions' THEN 10 WHEN 'payees' THEN 8 ELSE value END`); err != nil {
+		t.Fatalf("failed to update server knowledge: %s", err)
+	}
+
+	err := mirrorStorage{sqlite: sqlite, mirror: mirror}.Sync(context.Background(), func(ctx context.Context, tx storageTx) error {
+		serverKnowledge, err := tx.LoadServerKnowledge(ctx)
+		if err != nil {
+			return err
+		}
+		// the mirror is behind on transactions, SQLite on payees
+		assertInt(t, "transactions", serverKnowledge["transactions"], 10)
+		assertInt(t, "payees", serverKnowledge["payees"], 5)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Sync err = %s, want nil", err)
+	}
+}
+
+// postgresTestURL returns POSTGRES_TEST_URL, which has to point to an empty
+// database, e.g. postgres://postgres@localhost/ynab_test?sslmode=disable.
+// Without it a temporary server is started if initdb and pg_ctl are on the
+// PATH.
+func postgresTestURL(t *testing.T) string {
+	if url := os.Getenv("POSTGRES_TEST_URL"); url != "" {
+		return url
+	}
+	initdb, errInitdb := exec.LookPath("initdb")
+	pgCtl, errPgCtl := exec.LookPath("pg_ctl")
+	if errInitdb != nil || errPgCtl != nil {
+		t.Skip("POSTGRES_TEST_URL not set and initdb or pg_ctl not found")
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("POSTGRES_TEST_URL not set and PostgreSQL can't be started as root")
+	}
+
+	// the server only listens on a socket in its data directory
+	dir := t.TempDir()
+	run := func(name string, args ...string) {
+		if output, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s failed: %s\n%s", filepath.Base(name), err, output)
+		}
+	}
+	run(initdb, "-D", dir, "-U", "postgres", "-A", "trust")
+	run(pgCtl, "-D", dir, "-l", filepath.Join(dir, "server.log"), "-w", "-o", "-k "+dir+" -c listen_addresses=''", "start")
+	t.Cleanup(func() { run(pgCtl, "-D", dir, "-m", "immediate", "-w", "stop") })
+	return "postgres://postgres@/postgres?host=" + dir + "&sslmode=disable"
+}
+
+func TestPostgresStorageSync(t *testing.T) {
+	store, err := newPostgresStorage(postgresTestURL(t))
+	if err != nil {
+		t.Fatalf("newPostgresStorage err = %s, want nil", err)
+	}
+	defer store.Close()
+	db, sqlite := prepareFileDB(t)
+	defer db.Close()
+	testStorageSync(t, mirrorStorage{sqlite: sqlite, mirror: store}, db, store.db)
+
+	// both databases have the same ledger
+	ledger := func(db *sql.DB) [3]int {
+		var result [3]int
+		err := db.QueryRow(`SELECT COUNT(*), SUM(amount), COUNT(DISTINCT payee_name) FROM ledger`).Scan(&result[0], &result[1], &result[2])
+		if err != nil {
+			t.Fatalf("failed to query ledger: %s", err)
+		}
+		return result
+	}
+	assertValue(t, "PostgreSQL ledger", ledger(store.db), ledger(db))
+}
+
+func TestRebind(t *testing.T) {
+	got := rebind("SELECT name FROM category_month WHERE month_id = ? AND category_id = ?")
+	assertValue(t, "rebind", got, "SELECT name FROM category_month WHERE month_id = $1 AND category_id = $2")
+
+	got = rebind(`SELECT "why?" FROM "transaction" WHERE memo = 'what?' AND note = 'it''s ?' AND id = ?`)
+	assertValue(t, "rebind", got, `SELECT "why?" FROM "transaction" WHERE memo = 'what?' AND note = 'it''s ?' AND id = $1`)
+}
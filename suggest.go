@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// classifier is a multinomial naive Bayes classifier, which predicts the
+// category of a transaction from its features.
+type classifier struct {
+	categories map[string]*categoryStats
+	vocabulary map[string]bool
+	documents  int
+}
+
+type categoryStats struct {
+	documents int
+	tokens    int
+	counts    map[string]int
+}
+
+type suggestion struct {
+	CategoryID string
+	Confidence float64 // 0..1
+}
+
+func newClassifier() *classifier {
+	return &classifier{categories: make(map[string]*categoryStats), vocabulary: make(map[string]bool)}
+}
+
+func (c *classifier) train(categoryID string, features []string) {
+	stats, ok := c.categories[categoryID]
+	if !ok {
+		stats = &categoryStats{counts: make(map[string]int)}
+		c.categories[categoryID] = stats
+	}
+	stats.documents++
+	c.documents++
+	for _, feature := range features {
+		stats.counts[feature]++
+		stats.tokens++
+		c.vocabulary[feature] = true
+	}
+}
+
+// predict returns all categories ordered by their probability. Features that
+// never occurred in the training data are ignored.
+func (c *classifier) predict(features []string) []suggestion {
+	if c.documents == 0 {
+		return nil
+	}
+
+	logProbabilities := make(map[string]float64)
+	vocabularySize := float64(len(c.vocabulary))
+	for categoryID, stats := range c.categories {
+		p := math.Log(float64(stats.documents) / float64(c.documents))
+		for _, feature := range features {
+			if !c.vocabulary[feature] {
+				continue
+			}
+			// Laplace smoothing
+			p += math.Log((float64(stats.counts[feature]) + 1) / (float64(stats.tokens) + vocabularySize))
+		}
+		logProbabilities[categoryID] = p
+	}
+
+	// normalise with the log-sum-exp trick to avoid underflows
+	max := math.Inf(-1)
+	for _, p := range logProbabilities {
+		max = math.Max(max, p)
+	}
+	var sum float64
+	for _, p := range logProbabilities {
+		sum += math.Exp(p - max)
+	}
+
+	var suggestions []suggestion
+	for categoryID, p := range logProbabilities {
+		suggestions = append(suggestions, suggestion{CategoryID: categoryID, Confidence: math.Exp(p-max) / sum})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Confidence != suggestions[j].Confidence {
+			return suggestions[i].Confidence > suggestions[j].Confidence
+		}
+		return suggestions[i].CategoryID < suggestions[j].CategoryID
+	})
+	return suggestions
+}
+
+// transactionFeatures turns a transaction into the tokens the classifier
+// works with: words of payee and memo, the order of magnitude of the amount
+// and the weekday.
+func transactionFeatures(payee string, memo string, amount int, date string) []string {
+	var features []string
+	for _, token := range tokenize(payee) {
+		features = append(features, "payee:"+token)
+	}
+	for _, token := range tokenize(memo) {
+		features = append(features, "memo:"+token)
+	}
+
+	direction := "outflow"
+	if amount >= 0 {
+		direction = "inflow"
+	}
+	magnitude := 0
+	if amount != 0 {
+		magnitude = int(math.Log10(math.Abs(float64(amount)) / 1000))
+	}
+	features = append(features, fmt.Sprintf("amount:%s:%d", direction, magnitude))
+
+	if day, err := time.Parse("2006-01-02", date); err == nil {
+		features = append(features, "weekday:"+day.Weekday().String())
+	}
+	return features
+}
+
+// tokenize splits text into lower case words. Pure numbers are dropped, they
+// are mostly reference numbers which don't generalise.
+func tokenize(text string) []string {
+	var tokens []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(word) < 2 || strings.IndexFunc(word, unicode.IsLetter) == -1 {
+			continue
+		}
+		tokens = append(tokens, word)
+	}
+	return tokens
+}
+
+// trainClassifier learns from all categorised transactions and
+// subtransactions. Splits are learned through their subtransactions and
+// transfers are skipped since they don't have a category.
+func trainClassifier(ctx context.Context, tx *sql.Tx) (*classifier, error) {
+	res, err := tx.QueryContext(ctx, `
+		SELECT t.category_id, IFNULL(t.payee_name, ''), IFNULL(t.memo, ''), t.amount, t.date
+		FROM "transaction" t
+		WHERE t.deleted <> 1
+		AND IFNULL(t.category_id, '') <> ''
+		AND t.category_name <> 'Uncategorized'
+		AND IFNULL(t.transfer_account_id, '') = ''
+		AND NOT EXISTS(SELECT 1 FROM subtransaction s WHERE s.transaction_id = t.id AND s.deleted <> 1)
+		UNION ALL
+		SELECT s.category_id, IFNULL(t.payee_name, ''), IFNULL(s.memo, ''), s.amount, t.date
+		FROM subtransaction s
+		JOIN "transaction" t ON s.transaction_id = t.id
+		WHERE s.deleted <> 1 AND t.deleted <> 1
+		AND IFNULL(s.category_id, '') <> ''
+		AND IFNULL(s.transfer_account_id, '') = ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	c := newClassifier()
+	for res.Next() {
+		var categoryID, payee, memo, date string
+		var amount int
+		if err := res.Scan(&categoryID, &payee, &memo, &amount, &date); err != nil {
+			return nil, err
+		}
+		c.train(categoryID, transactionFeatures(payee, memo, amount, date))
+	}
+	return c, res.Err()
+}
+
+func suggestCommand(sqlite sqliteService, args []string) error {
+	flags := flag.NewFlagSet("suggest", flag.ExitOnError)
+	top := flags.Int("top", 3, "number of suggestions per transaction")
+	minConfidence := flags.Float64("min-confidence", 0.05, "hide suggestions below this confidence (0..1)")
+	flags.Parse(args)
+
+	return sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		c, err := trainClassifier(ctx, tx)
+		if err != nil {
+			return err
+		}
+		categoryNames, err := loadCategoryNames(ctx, tx)
+		if err != nil {
+			return err
+		}
+		transactions, err := loadEditableTransactions(ctx, tx, `
+			IFNULL(t.transfer_account_id, '') = ''
+			AND (IFNULL(t.category_id, '') = '' OR t.category_name = 'Uncategorized')`)
+		if err != nil {
+			return err
+		}
+
+		for _, t := range transactions {
+			if t.Split {
+				continue
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", t.ID, t.Date, formatAmount(t.Amount), t.PayeeName, t.Memo)
+			for i, s := range c.predict(transactionFeatures(t.PayeeName, t.Memo, t.Amount, t.Date)) {
+				if i >= *top || s.Confidence < *minConfidence {
+					break
+				}
+				fmt.Printf("\t%3.0f%%\t%s\n", s.Confidence*100, categoryNames[s.CategoryID])
+			}
+		}
+		return nil
+	})
+}
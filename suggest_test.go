@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("REWE Markt GmbH, Filiale 4711 / K1")
+	want := []string{"rewe", "markt", "gmbh", "filiale", "k1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("tokenize = %v, want %v", got, want)
+	}
+}
+
+func TestTransactionFeatures(t *testing.T) {
+	got := transactionFeatures("Shell", "fuel", -45500, "2022-12-24")
+	want := []string{"payee:shell", "memo:fuel", "amount:outflow:1", "weekday:Saturday"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("transactionFeatures = %v, want %v", got, want)
+	}
+}
+
+func TestClassifierPredict(t *testing.T) {
+	c := newClassifier()
+	if got := c.predict([]string{"payee:rewe"}); got != nil {
+		t.Fatalf("predict on empty classifier = %v, want nil", got)
+	}
+
+	c.train("groceries", transactionFeatures("REWE", "", -45000, "2022-12-03"))
+	c.train("groceries", transactionFeatures("EDEKA", "", -30000, "2022-12-10"))
+	c.train("groceries", transactionFeatures("REWE", "", -25000, "2022-12-17"))
+	c.train("fuel", transactionFeatures("Shell", "", -60000, "2022-12-05"))
+	c.train("fuel", transactionFeatures("Aral", "", -55000, "2022-12-19"))
+
+	suggestions := c.predict(transactionFeatures("REWE City", "", -40000, "2022-12-24"))
+	assertInt(t, "len(suggestions)", len(suggestions), 2)
+	assertValue(t, "suggestions[0].CategoryID", suggestions[0].CategoryID, "groceries")
+	if suggestions[0].Confidence < 0.8 {
+		t.Fatalf("suggestions[0].Confidence = %f, want >= 0.8", suggestions[0].Confidence)
+	}
+	sum := suggestions[0].Confidence + suggestions[1].Confidence
+	if sum < 0.999 || sum > 1.001 {
+		t.Fatalf("sum of confidences = %f, want 1", sum)
+	}
+}
+
+func TestTrainClassifier(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	var transactions Transactions
+	loadFixture("./fixtures/transactions.json", &transactions, t)
+	if err := updateTransactions(ctx, transactions, tx); err != nil {
+		t.Fatalf("updateTransactions err = %s, want nil", err)
+	}
+
+	c, err := trainClassifier(ctx, tx)
+	if err != nil {
+		t.Fatalf("trainClassifier err = %s, want nil", err)
+	}
+	// three plain transactions and the two subtransactions of the split
+	assertInt(t, "documents", c.documents, 5)
+	if _, ok := c.categories["843ff968-8a96-4c6c-bb97-a2fe4c28881c"]; !ok {
+		t.Fatal("category of subtransaction was not learned")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// defaultTagPatterns find tags like #vacation2024 and [work]
+var defaultTagPatterns = []string{`#([\p{L}\p{N}_-]+)`, `\[([^\[\]]+)\]`}
+
+// tagPatternsFromEnv reads the whitespace separated regular expressions of
+// TAG_PATTERNS, the default patterns are used if it isn't set. The first
+// group of a pattern is the tag, the whole match if there is no group.
+func tagPatternsFromEnv() ([]*regexp.Regexp, error) {
+	sources := defaultTagPatterns
+	if value, ok := os.LookupEnv("TAG_PATTERNS"); ok {
+		sources = strings.Fields(value)
+	}
+	var patterns []*regexp.Regexp
+	for _, source := range sources {
+		pattern, err := regexp.Compile(source)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse tag pattern %q: %s", source, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// extractTags returns the tags of a memo, lower case and with single spaces,
+// every tag once
+func extractTags(memo string, patterns []*regexp.Regexp) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		for _, match := range pattern.FindAllStringSubmatch(memo, -1) {
+			tag := match[0]
+			if len(match) > 1 {
+				tag = match[1]
+			}
+			tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+			if tag != "" && !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// updateTransactionTags extracts the tags of the transactions and their
+// subtransactions from the memos again. Tags of a split transaction's memo
+// belong to all of its subtransactions.
+func updateTransactionTags(ctx context.Context, tx *sql.Tx, transactionIDs []string) error {
+	if len(transactionIDs) == 0 {
+		return nil
+	}
+	patterns, err := tagPatternsFromEnv()
+	if err != nil {
+		return err
+	}
+	ids, err := json.Marshal(transactionIDs)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM transaction_tag WHERE transaction_id IN (SELECT value FROM json_each(?))`, string(ids))
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.QueryContext(ctx, `
+		SELECT t.id, '', IFNULL(t.memo, '')
+		FROM "transaction" t
+		WHERE t.id IN (SELECT value FROM json_each(?1)) AND t.deleted <> 1
+		UNION ALL
+		SELECT t.id, s.id, IFNULL(s.memo, '')
+		FROM subtransaction s
+		JOIN "transaction" t ON t.id = s.transaction_id
+		WHERE t.id IN (SELECT value FROM json_each(?1)) AND t.deleted <> 1 AND s.deleted <> 1`,
+		string(ids))
+	if err != nil {
+		return err
+	}
+	type tagged struct {
+		transactionID, subtransactionID string
+		tags                            []string
+	}
+	var memos []tagged
+	for res.Next() {
+		var transactionID, subtransactionID, memo string
+		if err := res.Scan(&transactionID, &subtransactionID, &memo); err != nil {
+			res.Close()
+			return err
+		}
+		if tags := extractTags(memo, patterns); len(tags) > 0 {
+			memos = append(memos, tagged{transactionID, subtransactionID, tags})
+		}
+	}
+	res.Close()
+	if err := res.Err(); err != nil {
+		return err
+	}
+
+	for _, memo := range memos {
+		for _, tag := range memo.tags {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO tag (name) VALUES (?) ON CONFLICT(name) DO NOTHING`, tag); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO transaction_tag (transaction_id, subtransaction_id, tag_id)
+				SELECT ?, ?, id FROM tag WHERE name = ?
+				ON CONFLICT DO NOTHING`,
+				memo.transactionID, memo.subtransactionID, tag)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tag WHERE id NOT IN (SELECT tag_id FROM transaction_tag)`)
+	return err
+}
+
+// tagsReport shows the spending per tag and category. A line of a split
+// transaction has the tags of its own memo and those of the transaction.
+// Rows without a category are the totals of the tag. Like in the cash flow
+// report, transfers between on-budget accounts are left out.
+func tagsReport(ctx context.Context, tx *sql.Tx, args []string) (report, string, error) {
+	flags := flag.NewFlagSet("report tags", flag.ExitOnError)
+	monthRange := monthRangeFlags(flags)
+	tag := flags.String("tag", "", "only show this tag")
+	format := flags.String("format", "table", "output format, table, csv, json or markdown")
+	flags.Parse(args)
+
+	from, to, err := monthRange()
+	if err != nil {
+		return report{}, "", err
+	}
+	name := strings.ToLower(strings.Join(strings.Fields(*tag), " "))
+
+	res, err := tx.QueryContext(ctx, `
+		WITH `+budgetLines+`,
+		tagged_line AS (
+			SELECT DISTINCT l.transaction_id, l.subtransaction_id, l.amount, l.category_name, tt.tag_id
+			FROM budget_line l
+			JOIN transaction_tag tt ON tt.transaction_id = l.transaction_id
+				AND tt.subtransaction_id IN ('', l.subtransaction_id)
+			WHERE (? = '' OR substr(l.date, 1, 7) || '-01' >= ?)
+			AND (? = '' OR substr(l.date, 1, 7) || '-01' <= ?)
+		)
+		SELECT tag.name, IFNULL(l.category_name, 'Uncategorized'), 0, -SUM(l.amount), COUNT(DISTINCT l.transaction_id)
+		FROM tagged_line l
+		JOIN tag ON tag.id = l.tag_id
+		WHERE (? = '' OR tag.name = ?)
+		GROUP BY tag.name, IFNULL(l.category_name, 'Uncategorized')
+		UNION ALL
+		SELECT tag.name, '', 1, -SUM(l.amount), COUNT(DISTINCT l.transaction_id)
+		FROM tagged_line l
+		JOIN tag ON tag.id = l.tag_id
+		WHERE (? = '' OR tag.name = ?)
+		GROUP BY tag.name
+		ORDER BY 1, 3, 2`,
+		from, from, to, to, name, name, name, name)
+	if err != nil {
+		return report{}, "", err
+	}
+	defer res.Close()
+
+	result := report{Columns: []string{"tag", "category", "spent", "transactions"}}
+	for res.Next() {
+		var tagName, category string
+		var total bool
+		var spent, transactions int
+		if err := res.Scan(&tagName, &category, &total, &spent, &transactions); err != nil {
+			return report{}, "", err
+		}
+		result.add(tagName, category, amount(spent), transactions)
+	}
+	if err := res.Err(); err != nil {
+		return report{}, "", err
+	}
+
+	return result, *format, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestExtractTags(t *testing.T) {
+	patterns, err := tagPatternsFromEnv()
+	if err != nil {
+		t.Fatalf("tagPatternsFromEnv err = %s, want nil", err)
+	}
+	for _, test := range []struct {
+		memo string
+		want []string
+	}{
+		{"", nil},
+		{"no tags here", nil},
+		{"Hotel #Vacation2024 #vacation2024", []string{"vacation2024"}},
+		{"[Work] lunch with [ home  office ] #client-a", []string{"client-a", "work", "home office"}},
+		{"[] # nothing", nil},
+	} {
+		if got := extractTags(test.memo, patterns); !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("extractTags(%q) = %v, want %v", test.memo, got, test.want)
+		}
+	}
+
+	custom := []*regexp.Regexp{regexp.MustCompile(`@\w+`)}
+	if got, want := extractTags("paid by @Alice #ignored", custom), []string{"@alice"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("extractTags with custom pattern = %v, want %v", got, want)
+	}
+}
+
+func TestTagPatternsFromEnv(t *testing.T) {
+	t.Setenv("TAG_PATTERNS", `%(\w+) \{(\w+)\}`)
+	patterns, err := tagPatternsFromEnv()
+	if err != nil {
+		t.Fatalf("tagPatternsFromEnv err = %s, want nil", err)
+	}
+	if got, want := extractTags("%trip {car} #ignored", patterns), []string{"trip", "car"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("extractTags = %v, want %v", got, want)
+	}
+
+	t.Setenv("TAG_PATTERNS", `(unclosed`)
+	if _, err := tagPatternsFromEnv(); err == nil {
+		t.Fatalf("tagPatternsFromEnv with invalid pattern err = nil, want error")
+	}
+}
+
+func TestTagsReport(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	var accounts Accounts
+	loadFixture("./fixtures/accounts.json", &accounts, t)
+	if err := updateAccounts(ctx, accounts, tx); err != nil {
+		t.Fatalf("updateAccounts err = %s, want nil", err)
+	}
+	var transactions Transactions
+	loadFixture("./fixtures/transactions.json", &transactions, t)
+	transactions.Data.Transactions[0].Memo = "#Trip water"
+	// the split's memo tags all lines, the subtransaction's memo only its own
+	transactions.Data.Transactions[3].Memo = "[trip]"
+	transactions.Data.Transactions[3].Subtransactions[1].Memo = "#work"
+	if err := updateTransactions(ctx, transactions, tx); err != nil {
+		t.Fatalf("updateTransactions err = %s, want nil", err)
+	}
+	assertValue(t, "tags", queryString(ctx, tx, `SELECT group_concat(name, ',') FROM (SELECT name FROM tag ORDER BY name)`, t), "trip,work")
+
+	r, _, err := tagsReport(ctx, tx, nil)
+	if err != nil {
+		t.Fatalf("tagsReport err = %s, want nil", err)
+	}
+	var buffer bytes.Buffer
+	r.write(&buffer, "csv")
+	want := "tag,category,spent,transactions\n" +
+		"trip,Electric,1.00,1\n" +
+		"trip,Rent/Mortgage,1.00,1\n" +
+		"trip,Water,23.00,1\n" +
+		"trip,,25.00,2\n" +
+		"work,Electric,1.00,1\n" +
+		"work,,1.00,1\n"
+	if got := buffer.String(); got != want {
+		t.Fatalf("tagsReport = %q, want %q", got, want)
+	}
+
+	// removing the tag from the memo removes the tag
+	transactions.Data.Transactions[3].Subtransactions[1].Memo = ""
+	if err := updateTransactions(ctx, transactions, tx); err != nil {
+		t.Fatalf("updateTransactions err = %s, want nil", err)
+	}
+	r, _, err = tagsReport(ctx, tx, []string{"-tag", "WORK"})
+	if err != nil {
+		t.Fatalf("tagsReport err = %s, want nil", err)
+	}
+	assertInt(t, "len(r.Rows)", len(r.Rows), 0)
+	assertValue(t, "tags", queryString(ctx, tx, `SELECT group_concat(name, ',') FROM tag`, t), "trip")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// brokenTransfer is a transfer without a matching counterpart
+type brokenTransfer struct {
+	ID                string
+	Date              string
+	AccountName       string
+	Amount            int
+	TransferAccountID string
+	Problem           string
+}
+
+// checkTransfers verifies that every transfer, including transfers of
+// subtransactions, references a counterpart with the opposite amount on the
+// transfer account. Subtransactions take date and account of their parent.
+func checkTransfers(ctx context.Context, tx *sql.Tx) ([]brokenTransfer, error) {
+	res, err := tx.QueryContext(ctx, `
+		WITH transfer_line AS (
+			SELECT t.id, t.date, t.account_id, IFNULL(t.account_name, '') AS account_name, t.amount,
+				t.transfer_account_id, IFNULL(t.transfer_transaction_id, '') AS transfer_transaction_id
+			FROM "transaction" t
+			WHERE t.deleted <> 1
+			UNION ALL
+			SELECT s.id, t.date, t.account_id, IFNULL(t.account_name, ''), s.amount,
+				s.transfer_account_id, IFNULL(s.transfer_transaction_id, '')
+			FROM subtransaction s
+			JOIN "transaction" t ON t.id = s.transaction_id
+			WHERE s.deleted <> 1 AND t.deleted <> 1
+		)
+		SELECT
+			l.id, l.date, l.account_name, l.amount, l.transfer_account_id, l.transfer_transaction_id,
+			a.id IS NOT NULL, IFNULL(a.deleted, 0),
+			c.id IS NOT NULL, IFNULL(c.account_id, ''), IFNULL(c.account_name, ''), IFNULL(c.amount, 0)
+		FROM transfer_line l
+		LEFT JOIN account a ON a.id = l.transfer_account_id
+		LEFT JOIN transfer_line c ON c.id = l.transfer_transaction_id
+		WHERE IFNULL(l.transfer_account_id, '') <> ''
+		ORDER BY l.date, l.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var broken []brokenTransfer
+	for res.Next() {
+		var b brokenTransfer
+		var transferTransactionID, counterpartAccountID, counterpartAccountName string
+		var accountExists, accountDeleted, counterpartExists bool
+		var counterpartAmount int
+		err := res.Scan(&b.ID, &b.Date, &b.AccountName, &b.Amount, &b.TransferAccountID, &transferTransactionID,
+			&accountExists, &accountDeleted,
+			&counterpartExists, &counterpartAccountID, &counterpartAccountName, &counterpartAmount)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case !accountExists:
+			b.Problem = "transfer account does not exist"
+		case accountDeleted:
+			b.Problem = "transfer account is deleted"
+		case transferTransactionID == "":
+			b.Problem = "no counterpart"
+		case !counterpartExists:
+			b.Problem = fmt.Sprintf("counterpart %s does not exist or is deleted", transferTransactionID)
+		case counterpartAccountID != b.TransferAccountID:
+			b.Problem = fmt.Sprintf("counterpart is on account %s", counterpartAccountName)
+		case counterpartAmount != -b.Amount:
+			b.Problem = fmt.Sprintf("counterpart amount is %s", formatAmount(counterpartAmount))
+		default:
+			continue
+		}
+		broken = append(broken, b)
+	}
+	return broken, res.Err()
+}
+
+func checkCommand(sqlite sqliteService, args []string) error {
+	if len(args) == 0 || args[0] != "transfers" {
+		return fmt.Errorf("usage: check transfers")
+	}
+
+	var broken []brokenTransfer
+	err := sqlite.Transaction(func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		broken, err = checkTransfers(ctx, tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, b := range broken {
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\n", b.Date, b.AccountName, formatAmount(b.Amount), b.ID, b.Problem)
+	}
+	if len(broken) > 0 {
+		return fmt.Errorf("found %d broken transfers", len(broken))
+	}
+	log.Print("all transfers have a counterpart")
+	return nil
+}
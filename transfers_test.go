@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckTransfers(t *testing.T) {
+	db, ctx, tx := prepareDBTx(t)
+	defer db.Close()
+
+	mustExec(ctx, tx, t, `INSERT INTO account (id, name, deleted) VALUES ('checking', 'Checking', 0), ('visa', 'Visa', 0), ('old', 'Old', 1)`)
+	for _, row := range []struct {
+		id, date, account, transfer, counterpart string
+		amount                                   int
+	}{
+		{"ok", "2022-01-01", "checking", "visa", "ok-visa", -100000},
+		{"ok-visa", "2022-01-01", "visa", "checking", "ok", 100000},
+		{"one-sided", "2022-01-02", "checking", "visa", "", -50000},
+		{"amount", "2022-01-03", "checking", "visa", "amount-visa", -70000},
+		{"amount-visa", "2022-01-03", "visa", "checking", "amount", 7000},
+		{"deleted-account", "2022-01-04", "checking", "old", "x", -1000},
+		{"split", "2022-01-05", "checking", "", "", -30000},
+		{"split-visa", "2022-01-05", "visa", "checking", "split-2", 20000},
+	} {
+		mustExec(ctx, tx, t, `INSERT INTO "transaction" (id, date, amount, account_id, account_name, transfer_account_id, transfer_transaction_id, deleted)
+			VALUES (?, ?, ?, ?, (SELECT name FROM account WHERE id = ?), NULLIF(?, ''), NULLIF(?, ''), 0)`,
+			row.id, row.date, row.amount, row.account, row.account, row.transfer, row.counterpart)
+	}
+	mustExec(ctx, tx, t, `INSERT INTO subtransaction (id, transaction_id, amount, transfer_account_id, transfer_transaction_id, deleted) VALUES
+		('split-1', 'split', -10000, NULL, NULL, 0),
+		('split-2', 'split', -20000, 'visa', 'split-visa', 0)`)
+
+	broken, err := checkTransfers(ctx, tx)
+	if err != nil {
+		t.Fatalf("checkTransfers err = %s, want nil", err)
+	}
+	var got []string
+	for _, b := range broken {
+		got = append(got, b.ID+": "+b.Problem)
+	}
+	want := []string{
+		"one-sided: no counterpart",
+		"amount: counterpart amount is 7.00",
+		"amount-visa: counterpart amount is -70.00",
+		"deleted-account: transfer account is deleted",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("checkTransfers = %q, want %q", got, want)
+	}
+	assertValue(t, "AccountName", broken[0].AccountName, "Checking")
+}
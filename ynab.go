@@ -1,21 +1,128 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 type YNAB struct {
 	prefix   string
 	apiKey   string
 	budgetId string
+	stats    *apiStats
 }
 
 func NewYNAB(prefix string, apiKey string, budgetId string) YNAB {
-	return YNAB{prefix: prefix, apiKey: apiKey, budgetId: budgetId}
+	return YNAB{prefix: prefix, apiKey: apiKey, budgetId: budgetId, stats: &apiStats{}}
+}
+
+// apiRequest identifies requests with the same method, endpoint and status
+// code
+type apiRequest struct {
+	Method   string
+	Endpoint string
+	Code     int
+}
+
+// apiStats are the number of requests sent and the usage of the rate limit
+// reported by the last response
+type apiStats struct {
+	mu       sync.Mutex
+	requests map[apiRequest]int
+	used     int
+	limit    int
+}
+
+// parseRateLimit parses the X-Rate-Limit header, e.g. "36/200" means 36 of
+// 200 requests in the current hour are used.
+func parseRateLimit(header string) (used int, limit int, ok bool) {
+	parts := strings.SplitN(header, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	used, errUsed := strconv.Atoi(strings.TrimSpace(parts[0]))
+	limit, errLimit := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if errUsed != nil || errLimit != nil {
+		return 0, 0, false
+	}
+	return used, limit, true
+}
+
+// endpointName removes the budget and all ids from the path of url, e.g.
+// .../budgets/last-used/months/2022-12-01/categories/ID becomes
+// months/categories.
+func endpointName(url string) string {
+	path := strings.SplitN(url, "?", 2)[0]
+	if i := strings.Index(path, "/budgets/"); i >= 0 {
+		path = path[i+len("/budgets/"):]
+		if j := strings.Index(path, "/"); j >= 0 {
+			path = path[j+1:]
+		} else {
+			path = ""
+		}
+	}
+	var names []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" && strings.Trim(segment, "abcdefghijklmnopqrstuvwxyz_") == "" {
+			names = append(names, segment)
+		}
+	}
+	if len(names) == 0 {
+		return "budgets"
+	}
+	return strings.Join(names, "/")
+}
+
+func (stats *apiStats) record(method string, url string, code int, rateLimit string) {
+	if stats == nil {
+		return
+	}
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	if stats.requests == nil {
+		stats.requests = make(map[apiRequest]int)
+	}
+	stats.requests[apiRequest{method, endpointName(url), code}]++
+	if used, limit, ok := parseRateLimit(rateLimit); ok {
+		stats.used, stats.limit = used, limit
+	}
+}
+
+// RemainingRequests returns how many requests are left in the current hour.
+// ok is false until a response reported the rate limit.
+func (ynab YNAB) RemainingRequests() (remaining int, ok bool) {
+	if ynab.stats == nil {
+		return 0, false
+	}
+	ynab.stats.mu.Lock()
+	defer ynab.stats.mu.Unlock()
+	if ynab.stats.limit == 0 {
+		return 0, false
+	}
+	return ynab.stats.limit - ynab.stats.used, true
+}
+
+// RequestCounts returns the number of requests sent by method, endpoint and
+// status code.
+func (ynab YNAB) RequestCounts() map[apiRequest]int {
+	counts := make(map[apiRequest]int)
+	if ynab.stats == nil {
+		return counts
+	}
+	ynab.stats.mu.Lock()
+	defer ynab.stats.mu.Unlock()
+	for request, count := range ynab.stats.requests {
+		counts[request] = count
+	}
+	return counts
 }
 
 type category struct {
@@ -146,6 +253,30 @@ type Transactions struct {
 	} `json:"data"`
 }
 
+// ScheduledTransactions GET /v1/budgets/:id/scheduled_transactions
+type ScheduledTransactions struct {
+	Data struct {
+		ScheduledTransactions []struct {
+			ID                string  `json:"id"`
+			DateFirst         string  `json:"date_first"`
+			DateNext          string  `json:"date_next"`
+			Frequency         string  `json:"frequency"`
+			Amount            int     `json:"amount"`
+			Memo              string  `json:"memo"`
+			FlagColor         *string `json:"flag_color"`
+			AccountID         string  `json:"account_id"`
+			AccountName       string  `json:"account_name"`
+			PayeeID           string  `json:"payee_id"`
+			PayeeName         string  `json:"payee_name"`
+			CategoryID        string  `json:"category_id"`
+			CategoryName      string  `json:"category_name"`
+			TransferAccountID string  `json:"transfer_account_id"`
+			Deleted           bool    `json:"deleted"`
+		} `json:"scheduled_transactions"`
+		ServerKnowledge int `json:"server_knowledge"`
+	} `json:"data"`
+}
+
 // Payees GET /v1/budgets/:budget_id/payees
 type Payees struct {
 	Data struct {
@@ -159,32 +290,91 @@ type Payees struct {
 	} `json:"data"`
 }
 
-func (ynab YNAB) request(url string) (*[]byte, error) {
+// ErrorResponse is returned by the API for every failed request
+type ErrorResponse struct {
+	Error struct {
+		ID     string `json:"id"`
+		Name   string `json:"name"`
+		Detail string `json:"detail"`
+	} `json:"error"`
+}
+
+// SaveTransaction is part of POST /v1/budgets/:id/transactions
+type SaveTransaction struct {
+	AccountID  string  `json:"account_id"`
+	Date       string  `json:"date"`
+	Amount     int     `json:"amount"`
+	PayeeName  *string `json:"payee_name,omitempty"`
+	CategoryID *string `json:"category_id,omitempty"`
+	Memo       *string `json:"memo,omitempty"`
+	Cleared    string  `json:"cleared,omitempty"`
+	Approved   bool    `json:"approved"`
+	ImportID   *string `json:"import_id,omitempty"`
+}
+
+// SaveTransactions POST /v1/budgets/:id/transactions
+type SaveTransactions struct {
+	Data struct {
+		TransactionIDs     []string `json:"transaction_ids"`
+		DuplicateImportIDs []string `json:"duplicate_import_ids"`
+		ServerKnowledge    int      `json:"server_knowledge"`
+	} `json:"data"`
+}
+
+func (ynab YNAB) request(ctx context.Context, url string) (*[]byte, error) {
+	return ynab.send(ctx, "GET", url, nil)
+}
+
+// send performs a request against the API. A non-nil body gets encoded as
+// JSON and is sent as request payload. The request is aborted when ctx is
+// cancelled.
+func (ynab YNAB) send(ctx context.Context, method string, url string, body interface{}) (*[]byte, error) {
+	var payload io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		payload = bytes.NewReader(encoded)
+	}
+
 	client := &http.Client{}
-	req, _ := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, method, url, payload)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ynab.apiKey))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 	res, err := client.Do(req)
 	if err != nil {
-		log.Fatal(err.Error())
+		return nil, err
 	}
 	defer res.Body.Close()
 
 	// https://api.youneedabudget.com/#rate-limiting
 	// every access token can generate 200 requests per hour
-	log.Printf("%s %v %s\n", url, res.Status, res.Header.Get("X-Rate-Limit"))
+	log.Printf("%s %s %v %s\n", method, url, res.Status, res.Header.Get("X-Rate-Limit"))
+	ynab.stats.record(method, url, res.StatusCode, res.Header.Get("X-Rate-Limit"))
+
+	content, _ := io.ReadAll(res.Body)
 
 	// check if response outside of 2xx or 3xx code
 	if !(res.StatusCode >= 200 && res.StatusCode <= 399) {
+		var apiError ErrorResponse
+		if json.Unmarshal(content, &apiError) == nil && apiError.Error.Detail != "" {
+			return nil, fmt.Errorf("failed request with status code %d: %s", res.StatusCode, apiError.Error.Detail)
+		}
 		return nil, fmt.Errorf("failed request with status code %d", res.StatusCode)
 	}
 
-	bytes, _ := io.ReadAll(res.Body)
-	return &bytes, nil
+	return &content, nil
 }
 
-func (ynab YNAB) LoadCategories(serverKnowledge int) Categories {
+func (ynab YNAB) LoadCategories(ctx context.Context, serverKnowledge int) Categories {
 	url := fmt.Sprintf("%s/budgets/%s/categories?last_knowledge_of_server=%d", ynab.prefix, ynab.budgetId, serverKnowledge)
-	bytes, err := ynab.request(url)
+	bytes, err := ynab.request(ctx, url)
 	if err != nil {
 		log.Panic("failed to load categories list")
 	}
@@ -193,8 +383,9 @@ func (ynab YNAB) LoadCategories(serverKnowledge int) Categories {
 	return categories
 }
 
-func (ynab YNAB) LoadMonths(serverKnowledge int) Months {
+func (ynab YNAB) LoadMonths(ctx context.Context, serverKnowledge int) Months {
 	bytes, err := ynab.request(
+		ctx,
 		fmt.Sprintf("%s/budgets/%s/months?last_knowledge_of_server=%d",
 			ynab.prefix,
 			ynab.budgetId,
@@ -209,10 +400,11 @@ func (ynab YNAB) LoadMonths(serverKnowledge int) Months {
 	return months
 }
 
-func (ynab YNAB) LoadCategoryMonths(monthID string) CategoryMonth {
+func (ynab YNAB) LoadCategoryMonths(ctx context.Context, monthID string) CategoryMonth {
 	var categoryMonth CategoryMonth
 
 	bytes, err := ynab.request(
+		ctx,
 		fmt.Sprintf("%s/budgets/%s/months/%s", ynab.prefix, ynab.budgetId, monthID),
 	)
 	if err != nil {
@@ -222,8 +414,9 @@ func (ynab YNAB) LoadCategoryMonths(monthID string) CategoryMonth {
 	return categoryMonth
 }
 
-func (ynab YNAB) LoadAccounts(serverKnowledge int) Accounts {
+func (ynab YNAB) LoadAccounts(ctx context.Context, serverKnowledge int) Accounts {
 	bytes, err := ynab.request(
+		ctx,
 		fmt.Sprintf("%s/budgets/%s/accounts?last_knowledge_of_server=%d",
 			ynab.prefix,
 			ynab.budgetId,
@@ -238,8 +431,9 @@ func (ynab YNAB) LoadAccounts(serverKnowledge int) Accounts {
 	return accounts
 }
 
-func (ynab YNAB) LoadTransactions(serverKnowledge int) Transactions {
+func (ynab YNAB) LoadTransactions(ctx context.Context, serverKnowledge int) Transactions {
 	bytes, err := ynab.request(
+		ctx,
 		fmt.Sprintf("%s/budgets/%s/transactions?last_knowledge_of_server=%d",
 			ynab.prefix,
 			ynab.budgetId,
@@ -253,8 +447,25 @@ func (ynab YNAB) LoadTransactions(serverKnowledge int) Transactions {
 	return transactions
 }
 
-func (ynab YNAB) LoadPayees(serverKnowledge int) Payees {
+func (ynab YNAB) LoadScheduledTransactions(ctx context.Context, serverKnowledge int) ScheduledTransactions {
 	bytes, err := ynab.request(
+		ctx,
+		fmt.Sprintf("%s/budgets/%s/scheduled_transactions?last_knowledge_of_server=%d",
+			ynab.prefix,
+			ynab.budgetId,
+			serverKnowledge),
+	)
+	if err != nil {
+		log.Panic("Failed to load scheduled transactions list")
+	}
+	var scheduledTransactions ScheduledTransactions
+	json.Unmarshal(*bytes, &scheduledTransactions)
+	return scheduledTransactions
+}
+
+func (ynab YNAB) LoadPayees(ctx context.Context, serverKnowledge int) Payees {
+	bytes, err := ynab.request(
+		ctx,
 		fmt.Sprintf(
 			"%s/budgets/%s/payees?last_knowledge_of_server=%d",
 			ynab.prefix,
@@ -269,3 +480,74 @@ func (ynab YNAB) LoadPayees(serverKnowledge int) Payees {
 	json.Unmarshal(*bytes, &payees)
 	return payees
 }
+
+// CreateTransactions creates multiple transactions with a single request.
+// Transactions with an import_id that already exists in the budget are
+// skipped by YNAB and reported as duplicates.
+func (ynab YNAB) CreateTransactions(ctx context.Context, transactions []SaveTransaction) (SaveTransactions, error) {
+	var saved SaveTransactions
+	bytes, err := ynab.send(
+		ctx,
+		"POST",
+		fmt.Sprintf("%s/budgets/%s/transactions", ynab.prefix, ynab.budgetId),
+		map[string][]SaveTransaction{"transactions": transactions},
+	)
+	if err != nil {
+		return saved, err
+	}
+	err = json.Unmarshal(*bytes, &saved)
+	return saved, err
+}
+
+// SaveMonthCategory PATCH /v1/budgets/:id/months/:month/categories/:category_id
+type SaveMonthCategory struct {
+	Data struct {
+		Category        category `json:"category"`
+		ServerKnowledge int      `json:"server_knowledge"`
+	} `json:"data"`
+}
+
+// UpdateMonthCategory sets the budgeted amount of a category for a month.
+func (ynab YNAB) UpdateMonthCategory(ctx context.Context, month string, categoryID string, budgeted int) (SaveMonthCategory, error) {
+	var saved SaveMonthCategory
+	bytes, err := ynab.send(
+		ctx,
+		"PATCH",
+		fmt.Sprintf("%s/budgets/%s/months/%s/categories/%s", ynab.prefix, ynab.budgetId, month, categoryID),
+		map[string]map[string]int{"category": {"budgeted": budgeted}},
+	)
+	if err != nil {
+		return saved, err
+	}
+	err = json.Unmarshal(*bytes, &saved)
+	return saved, err
+}
+
+// UpdateTransaction is part of PATCH /v1/budgets/:id/transactions. Fields that
+// are nil stay unchanged.
+type UpdateTransaction struct {
+	ID         string  `json:"id"`
+	CategoryID *string `json:"category_id,omitempty"`
+	PayeeID    *string `json:"payee_id,omitempty"`
+	PayeeName  *string `json:"payee_name,omitempty"`
+	Memo       *string `json:"memo,omitempty"`
+	FlagColor  *string `json:"flag_color,omitempty"`
+	Approved   *bool   `json:"approved,omitempty"`
+	Cleared    *string `json:"cleared,omitempty"`
+}
+
+// UpdateTransactions updates multiple transactions with a single request.
+func (ynab YNAB) UpdateTransactions(ctx context.Context, transactions []UpdateTransaction) (SaveTransactions, error) {
+	var saved SaveTransactions
+	bytes, err := ynab.send(
+		ctx,
+		"PATCH",
+		fmt.Sprintf("%s/budgets/%s/transactions", ynab.prefix, ynab.budgetId),
+		map[string][]UpdateTransaction{"transactions": transactions},
+	)
+	if err != nil {
+		return saved, err
+	}
+	err = json.Unmarshal(*bytes, &saved)
+	return saved, err
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func assertValue(t *testing.T, fieldName string, got interface{}, want interface{}) {
@@ -62,7 +66,7 @@ func TestLoadCategories(t *testing.T) {
 	defer ts.Close()
 
 	ynab := NewYNAB(ts.URL, "token", "last-user")
-	data := ynab.LoadCategories(0).Data
+	data := ynab.LoadCategories(context.Background(), 0).Data
 	if got, want := data.ServerKnowledge, 98; got != want {
 		t.Fatalf("data.ServerKnowledge = %d, want %d", got, want)
 	}
@@ -81,7 +85,7 @@ func TestLoadCategoryMonths(t *testing.T) {
 	defer ts.Close()
 
 	ynab := NewYNAB(ts.URL, "token", "last-used")
-	categoryMonth := ynab.LoadCategoryMonths("month-id")
+	categoryMonth := ynab.LoadCategoryMonths(context.Background(), "month-id")
 	if got, want := categoryMonth.Data.Month.Categories[0].Name, "Electric 213"; got != want {
 		t.Fatalf("categoryMonth.Data.Category.Name = %q, want %q", got, want)
 	}
@@ -112,7 +116,7 @@ func TestLoadMonth(t *testing.T) {
 	defer ts.Close()
 
 	ynab := NewYNAB(ts.URL, "token", "last-user")
-	months := ynab.LoadMonths(0)
+	months := ynab.LoadMonths(context.Background(), 0)
 	if got, want := len(months.Data.Months), 2; got != want {
 		t.Fatalf("len(months.Data.Months) = %d, want %d", got, want)
 	}
@@ -126,7 +130,7 @@ func TestAccounts(t *testing.T) {
 	defer ts.Close()
 
 	ynab := NewYNAB(ts.URL, "token", "last-used")
-	accounts := ynab.LoadAccounts(0)
+	accounts := ynab.LoadAccounts(context.Background(), 0)
 	if got, want := len(accounts.Data.Accounts), 2; got != want {
 		t.Fatalf("len(accounts.Data.Accounts) = %d, want %d", got, want)
 	}
@@ -151,7 +155,7 @@ func TestTransactions(t *testing.T) {
 	defer ts.Close()
 
 	ynab := NewYNAB(ts.URL, "token", "last-used")
-	transactions := ynab.LoadTransactions(0)
+	transactions := ynab.LoadTransactions(context.Background(), 0)
 	if got, want := len(transactions.Data.Transactions), 4; got != want {
 		t.Fatalf("len(transactions.Data.Transactions) = %d, want %d", got, want)
 	}
@@ -177,12 +181,31 @@ func TestTransactions(t *testing.T) {
 	assertValue(t, "len(Subtransactions)=0", len(first.Subtransactions), 0)
 }
 
+func TestScheduledTransactions(t *testing.T) {
+	ts := fixtureGET(t, "./fixtures/scheduled_transactions.json")
+	defer ts.Close()
+
+	ynab := NewYNAB(ts.URL, "token", "last-used")
+	scheduledTransactions := ynab.LoadScheduledTransactions(context.Background(), 0)
+	if got, want := len(scheduledTransactions.Data.ScheduledTransactions), 2; got != want {
+		t.Fatalf("len(scheduledTransactions.Data.ScheduledTransactions) = %d, want %d", got, want)
+	}
+
+	first := scheduledTransactions.Data.ScheduledTransactions[0]
+	assertValue(t, "ID", first.ID, "0c5d5f1a-0d6b-4a4e-9b2b-4f4cc5e2c8a1")
+	assertValue(t, "DateNext", first.DateNext, "2021-12-01")
+	assertValue(t, "Frequency", first.Frequency, "monthly")
+	assertInt(t, "Amount", first.Amount, -23000)
+	assertValue(t, "TransferAccountID", first.TransferAccountID, "")
+	assertValue(t, "TransferAccountID", scheduledTransactions.Data.ScheduledTransactions[1].TransferAccountID, "95d0b9ce-2c8d-436c-b239-590aa963e547")
+}
+
 func TestPayees(t *testing.T) {
 	ts := fixtureGET(t, "./fixtures/payees.json")
 	defer ts.Close()
 
 	ynab := NewYNAB(ts.URL, "token", "last-used")
-	payees := ynab.LoadPayees(0)
+	payees := ynab.LoadPayees(context.Background(), 0)
 	if got, want := len(payees.Data.Payees), 7; got != want {
 		t.Fatalf("len(payees.Data.Payees) = %d, want %d", got, want)
 	}
@@ -193,3 +216,133 @@ func TestPayees(t *testing.T) {
 	assertNil(t, "TransferAccountId", first.TransferAccountID)
 	assertValue(t, "Deleted", first.Deleted, false)
 }
+
+func TestCreateTransactions(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Method, "POST"; got != want {
+			t.Errorf("r.Method = %q, want %q", got, want)
+		}
+		if got, want := r.URL.Path, "/budgets/last-used/transactions"; got != want {
+			t.Errorf("r.URL.Path = %q, want %q", got, want)
+		}
+		var body struct {
+			Transactions []SaveTransaction `json:"transactions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode body: %s", err)
+		}
+		if got, want := len(body.Transactions), 2; got != want {
+			t.Errorf("len(body.Transactions) = %d, want %d", got, want)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"data": {"transaction_ids": ["a"], "duplicate_import_ids": ["YNAB:-1000:2022-01-01:1"], "server_knowledge": 100}}`))
+	}))
+	defer ts.Close()
+
+	ynab := NewYNAB(ts.URL, "token", "last-used")
+	importID := "YNAB:-1000:2022-01-01:1"
+	saved, err := ynab.CreateTransactions(context.Background(), []SaveTransaction{
+		{AccountID: "account", Date: "2022-01-01", Amount: -1000, ImportID: &importID},
+		{AccountID: "account", Date: "2022-01-02", Amount: -2000},
+	})
+	if err != nil {
+		t.Fatalf("CreateTransactions err = %s, want nil", err)
+	}
+	assertInt(t, "len(TransactionIDs)", len(saved.Data.TransactionIDs), 1)
+	assertInt(t, "len(DuplicateImportIDs)", len(saved.Data.DuplicateImportIDs), 1)
+}
+
+func TestRequestError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": {"id": "400", "name": "bad_request", "detail": "date must not be in the future"}}`))
+	}))
+	defer ts.Close()
+
+	ynab := NewYNAB(ts.URL, "token", "last-used")
+	_, err := ynab.CreateTransactions(context.Background(), nil)
+	if err == nil {
+		t.Fatal("CreateTransactions err = nil, want error")
+	}
+	if got, want := err.Error(), "failed request with status code 400: date must not be in the future"; got != want {
+		t.Fatalf("err = %q, want %q", got, want)
+	}
+}
+
+func TestRequestCancelled(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ynab := NewYNAB(ts.URL, "token", "last-used")
+	if _, err := ynab.request(ctx, ts.URL+"/budgets/last-used/payees"); err == nil {
+		t.Fatal("request err = nil, want error")
+	}
+	if ctx.Err() == nil {
+		t.Fatal("request returned before ctx was cancelled")
+	}
+}
+
+func TestUpdateMonthCategory(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Method, "PATCH"; got != want {
+			t.Errorf("r.Method = %q, want %q", got, want)
+		}
+		if got, want := r.URL.Path, "/budgets/last-used/months/2022-12-01/categories/category-id"; got != want {
+			t.Errorf("r.URL.Path = %q, want %q", got, want)
+		}
+		var body struct {
+			Category struct {
+				Budgeted int `json:"budgeted"`
+			} `json:"category"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode body: %s", err)
+		}
+		if got, want := body.Category.Budgeted, 50000; got != want {
+			t.Errorf("body.Category.Budgeted = %d, want %d", got, want)
+		}
+		w.Write([]byte(`{"data": {"category": {"id": "category-id", "budgeted": 50000}, "server_knowledge": 101}}`))
+	}))
+	defer ts.Close()
+
+	ynab := NewYNAB(ts.URL, "token", "last-used")
+	saved, err := ynab.UpdateMonthCategory(context.Background(), "2022-12-01", "category-id", 50000)
+	if err != nil {
+		t.Fatalf("UpdateMonthCategory err = %s, want nil", err)
+	}
+	assertInt(t, "Budgeted", saved.Data.Category.Budgeted, 50000)
+}
+
+func TestPatchTransactions(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Method, "PATCH"; got != want {
+			t.Errorf("r.Method = %q, want %q", got, want)
+		}
+		var body struct {
+			Transactions []map[string]interface{} `json:"transactions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode body: %s", err)
+		}
+		want := []map[string]interface{}{{"id": "a", "approved": false}}
+		if !reflect.DeepEqual(body.Transactions, want) {
+			t.Errorf("body.Transactions = %v, want %v", body.Transactions, want)
+		}
+		w.Write([]byte(`{"data": {"transaction_ids": ["a"], "server_knowledge": 102}}`))
+	}))
+	defer ts.Close()
+
+	ynab := NewYNAB(ts.URL, "token", "last-used")
+	approved := false
+	saved, err := ynab.UpdateTransactions(context.Background(), []UpdateTransaction{{ID: "a", Approved: &approved}})
+	if err != nil {
+		t.Fatalf("UpdateTransactions err = %s, want nil", err)
+	}
+	assertInt(t, "len(TransactionIDs)", len(saved.Data.TransactionIDs), 1)
+}